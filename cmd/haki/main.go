@@ -17,10 +17,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/zentrope/haki/exec"
+	"github.com/zentrope/haki/lang"
 )
 
 func main() {
@@ -34,7 +36,12 @@ func main() {
 	}
 
 	if err := exec.InvokeScript(argv[1], argv[2:]); err != nil {
-		fmt.Printf("ERROR: %v\n", err)
+		var e *lang.Error
+		if errors.As(err, &e) {
+			fmt.Fprint(os.Stderr, e.Diagnostic())
+		} else {
+			fmt.Printf("ERROR: %v\n", err)
+		}
 		os.Exit(1)
 	}
 
@@ -31,7 +31,7 @@ func InvokeScript(filename string, args []string) error {
 		return err
 	}
 
-	return runScript(script, args)
+	return runScript(filename, script, args)
 }
 
 var hashBangRe = regexp.MustCompile("(?m)^[#][!].*$")
@@ -46,11 +46,13 @@ func loadScript(fname string) (string, error) {
 	return hashBangRe.ReplaceAllString(str, ""), nil
 }
 
-func runScript(script string, args []string) error {
+func runScript(filename, script string, args []string) error {
 	interpreter := lang.NewScriptInterpreter(lang.TCO, args)
 	setVersionEnv(interpreter)
 
-	reader := lang.NewReader(lang.Core, script)
+	reader := lang.NewReader()
+	reader.AppendSource("<core>", lang.Core)
+	reader.AppendSource(filename, script)
 
 	_, err := interpreter.Run(reader)
 	return err
@@ -22,27 +22,37 @@ import (
 	"strings"
 )
 
-type primitiveFunc func(args []Expression) (Expression, error)
-type primitivesMap map[string]primitiveFunc
-
-var builtins = make(primitivesMap, 0)
-
-func init() {
-	prims := []primitivesMap{
-		logicBuiltins,   // builtins_logic
-		mathBuiltins,    // builtins_math
-		stringBuiltins,  // builtins_string
-		listBuiltins,    // builtins_list
-		fileioBuiltins,  // builtins_fileio
-		hashmapBuiltins, // builtins_hashmap
-		writeBuiltins,   // builtins_write
-		osBuiltins,      // builtins_os
-	}
-	for _, prim := range prims {
-		for name, fn := range prim {
-			builtins[name] = fn
-		}
-	}
+// PrimitiveFunc is a function implemented in Go and callable from haki.
+type PrimitiveFunc func(args []Expression) (Expression, error)
+
+type primitivesMap map[string]PrimitiveFunc
+
+// Stateless builtins, shared read-only by every interpreter.
+var builtins = []primitivesMap{
+	logicBuiltins,      // builtins_logic
+	mathBuiltins,       // builtins_math
+	stringBuiltins,     // builtins_string
+	listBuiltins,       // builtins_list
+	hashmapBuiltins,    // builtins_hashmap
+	errorBuiltins,      // builtins_error
+	atomBuiltins,       // builtins_atom
+	collectionBuiltins, // builtins_collection
+	metaBuiltins,       // define
+}
+
+// Builtins needing per-interpreter state are bound to a session when
+// an environment is created, and only if the session has been granted
+// their capability.
+var sessionBuiltins = []struct {
+	capability Capability
+	group      func(*session) primitivesMap
+}{
+	{Pure, writeBuiltins},         // builtins_write
+	{CapFileRead, fileioBuiltins}, // builtins_fileio
+	{CapFileRead, dirBuiltins},    // builtins_os
+	{CapExec, execBuiltins},       // builtins_os
+	{CapExit, exitBuiltins},       // builtins_os
+	{CapEnv, envBuiltins},         // builtins_os
 }
 
 // TODO: Move to type checking.
@@ -58,19 +68,6 @@ func verifyStrings(args []Expression) error {
 	return nil
 }
 
-// TODO: Move to type checking.
-func verifyNums(args []Expression) error {
-	for _, arg := range args {
-		switch arg.tag {
-		case ExpInteger, ExpFloat:
-			continue
-		default:
-			return errors.New("all arguments must be numbers")
-		}
-	}
-	return nil
-}
-
 //-----------------------------------------------------------------------------
 // Type checking
 //-----------------------------------------------------------------------------
@@ -97,6 +94,20 @@ func ckArityAtLeast(numArgs int) spec {
 	}
 }
 
+func ckArityRange(min, max int) spec {
+	return func(sig string, args []Expression) error {
+		size := len(args)
+		if size < min {
+			return ckArityAtLeast(min)(sig, args)
+		}
+		if max >= 0 && size > max {
+			return fmt.Errorf("'%v' expects at most '%v' arg(s), you provided '%v'",
+				sig, max, size)
+		}
+		return nil
+	}
+}
+
 func ckArityOneOf(pos ...int) spec {
 	return func(sig string, args []Expression) error {
 		size := len(args)
@@ -156,7 +167,7 @@ func ckComp(specs []spec) spec {
 }
 
 func ckCountable(pos int) spec {
-	return ckMultiType(pos, ExpString, ExpList, ExpHashMap)
+	return ckMultiType(pos, ExpString, ExpList, ExpHashMap, ExpVector, ExpSet)
 }
 
 func ckString(pos ...int) spec {
@@ -189,6 +200,29 @@ func ckInt(pos ...int) spec {
 	return ckComp(ckTypes(ExpInteger, pos...))
 }
 
+func ckNumber(pos ...int) spec {
+	return func(sig string, args []Expression) error {
+		for _, p := range pos {
+			if err := ckMultiType(p, ExpInteger, ExpFloat)(sig, args); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ckNumbers checks that every arg is a number.
+func ckNumbers() spec {
+	return func(sig string, args []Expression) error {
+		for i := range args {
+			if err := ckNumber(i)(sig, args); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func ckMap(pos ...int) spec {
 	return ckComp(ckTypes(ExpHashMap, pos...))
 }
@@ -197,10 +231,22 @@ func ckList(pos int) spec {
 	return ckType(pos, ExpList)
 }
 
+func ckSequence(pos int) spec {
+	return ckMultiType(pos, ExpList, ExpVector)
+}
+
 func ckHandle(pos int) spec {
 	return ckType(pos, ExpFile)
 }
 
+func ckError(pos int) spec {
+	return ckType(pos, ExpError)
+}
+
+func ckAtom(pos int) spec {
+	return ckType(pos, ExpAtom)
+}
+
 func typeCheck(sig string, args []Expression, specs ...spec) error {
 	for _, spec := range specs {
 		if err := spec(sig, args); err != nil {
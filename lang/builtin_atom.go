@@ -0,0 +1,184 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+var atomBuiltins = primitivesMap{
+	"atom":             _atom,
+	"atom?":            _atomP,
+	"deref":            _deref,
+	"reset!":           _reset,
+	"compare-and-set!": _compareAndSet,
+}
+
+// atomRef is a mutable reference to a value. Copies of an atom
+// expression refer to the same value, however they were captured.
+type atomRef struct {
+	mu    sync.Mutex
+	value Expression
+	id    uint64 // tells atoms apart, for hashing
+}
+
+var atomCounter uint64
+
+// NewAtomExpr returns an atom holding value.
+func NewAtomExpr(value Expression) Expression {
+	ref := &atomRef{value: value, id: atomic.AddUint64(&atomCounter, 1)}
+	return Expression{
+		tag:  ExpAtom,
+		atom: ref,
+	}
+}
+
+func (a *atomRef) get() Expression {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.value
+}
+
+func (a *atomRef) set(value Expression) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.value = value
+}
+
+// compareAndSet sets the atom to value if it's still equal to old.
+func (a *atomRef) compareAndSet(old, value Expression) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.value.Equals(old) {
+		return false
+	}
+	a.value = value
+	return true
+}
+
+//-----------------------------------------------------------------------------
+// implementations
+//-----------------------------------------------------------------------------
+
+func _atom(args []Expression) (Expression, error) {
+	if err := typeCheck("(atom val)", args, ckArity(1)); err != nil {
+		return NilExpression, err
+	}
+	return NewAtomExpr(args[0]), nil
+}
+
+func _atomP(args []Expression) (Expression, error) {
+	if err := typeCheck("(atom? val)", args, ckArity(1)); err != nil {
+		return NilExpression, err
+	}
+	return NewBoolExpr(args[0].IsAtomRef()), nil
+}
+
+func _deref(args []Expression) (Expression, error) {
+	if err := typeCheck("(deref atom)", args, ckArity(1), ckAtom(0)); err != nil {
+		return NilExpression, err
+	}
+	return args[0].atom.get(), nil
+}
+
+func _reset(args []Expression) (Expression, error) {
+	if err := typeCheck("(reset! atom val)", args, ckArity(2), ckAtom(0)); err != nil {
+		return NilExpression, err
+	}
+	args[0].atom.set(args[1])
+	return args[1], nil
+}
+
+func _compareAndSet(args []Expression) (Expression, error) {
+	if err := typeCheck("(compare-and-set! atom old new)", args, ckArity(3), ckAtom(0)); err != nil {
+		return NilExpression, err
+	}
+	return NewBoolExpr(args[0].atom.compareAndSet(args[1], args[2])), nil
+}
+
+//-----------------------------------------------------------------------------
+// Special forms
+//-----------------------------------------------------------------------------
+
+const swapSig = "(swap! atom fn args…)"
+
+// evalSwap sets an atom to the result of applying a function to its
+// value and any extra args. If the atom changes while the function's
+// running, the function is applied again to the new value.
+func evalSwap(interp Interpreter, env *Environment, args Expression) (Expression, error) {
+	if err := typeCheck(swapSig, args.list, ckArityAtLeast(2)); err != nil {
+		return NilExpression, err
+	}
+
+	argv := make([]Expression, 0, len(args.list))
+	for _, arg := range args.list {
+		value, err := interp.Evaluate(env, arg)
+		if err != nil {
+			return NilExpression, err
+		}
+		argv = append(argv, value)
+	}
+	return swapAtom(interp, argv)
+}
+
+// swapAtom applies the function in argv[1] to the value of the atom in
+// argv[0] and the rest of argv, until the atom's set.
+func swapAtom(interp Interpreter, argv []Expression) (Expression, error) {
+	if err := typeCheck(swapSig, argv, ckAtom(0)); err != nil {
+		return NilExpression, err
+	}
+
+	ref, fn, extra := argv[0].atom, argv[1], argv[2:]
+	for {
+		old := ref.get()
+		value, err := interp.Apply(fn, append([]Expression{old}, extra...))
+		if err != nil {
+			return NilExpression, err
+		}
+		if ref.compareAndSet(old, value) {
+			return value, nil
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// SET!
+//-----------------------------------------------------------------------------
+
+const setSig = "(set! name val)"
+
+// evalSet rebinds the innermost variable with the given name, in the
+// frame it was bound in. Closures share the frames they capture, so the
+// change is seen by every function and scope the variable is visible
+// to, including closures called after the scope has returned.
+func evalSet(interp Interpreter, env *Environment, args Expression) (Expression, error) {
+	if err := typeCheck(setSig, args.list, ckArity(2), ckType(0, ExpSymbol)); err != nil {
+		return NilExpression, err
+	}
+
+	value, err := interp.Evaluate(env, args.list[1])
+	if err != nil {
+		return NilExpression, err
+	}
+
+	name := args.list[0].symbol
+	if !env.assign(name, value) {
+		return nilExpr("%v «-- '%v' is not defined", setSig, name)
+	}
+	return value, nil
+}
@@ -0,0 +1,195 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"strings"
+)
+
+var collectionBuiltins = primitivesMap{
+	"keyword":   _keyword,
+	"keyword?":  _keywordP,
+	"vector":    _vector,
+	"vector?":   _vectorP,
+	"hash-set":  _hashSet,
+	"set?":      _setP,
+	"contains?": _containsP,
+}
+
+// NewKeywordExpr returns the keyword :name.
+func NewKeywordExpr(name string) Expression {
+	return NewExpr(ExpKeyword, ":"+strings.TrimPrefix(name, ":"))
+}
+
+func isKeyword(e Expression, name string) bool {
+	return e.tag == ExpKeyword && e.symbol == name
+}
+
+// NewVectorExpr returns a vector of elems.
+func NewVectorExpr(elems []Expression) Expression {
+	return Expression{tag: ExpVector, list: elems, cached: newHashCell()}
+}
+
+// NewSetExpr returns a set of the distinct elems. Sets can't contain
+// nil.
+func NewSetExpr(elems []Expression) Expression {
+	set := newHakiMap()
+	for _, e := range elems {
+		set.set(e, e)
+	}
+	return newSetExpr(set)
+}
+
+func newSetExpr(set *HakiHashMap) Expression {
+	return Expression{tag: ExpSet, hashMap: set, cached: newHashCell()}
+}
+
+//-----------------------------------------------------------------------------
+// Literals
+//-----------------------------------------------------------------------------
+
+// isConstant returns true if evaluating e gives e.
+func isConstant(e Expression) bool {
+	switch e.tag {
+	case ExpNil, ExpBool, ExpInteger, ExpFloat, ExpString, ExpKeyword:
+		return true
+	case ExpVector:
+		for _, x := range e.list {
+			if !isConstant(x) {
+				return false
+			}
+		}
+		return true
+	case ExpHashMap, ExpSet:
+		constant := true
+		e.hashMap.each(func(k, v Expression) {
+			constant = constant && isConstant(k) && isConstant(v)
+		})
+		return constant
+	}
+	return false
+}
+
+// evalCollection evaluates the elements of a vector, set or hash-map
+// read from a literal, returning a collection of their values.
+func evalCollection(interp Interpreter, env *Environment, expr Expression) (Expression, error) {
+	if isConstant(expr) {
+		return expr, nil
+	}
+
+	elems := literalElems(expr)
+	values := make([]Expression, 0, len(elems))
+	for _, e := range elems {
+		value, err := interp.Evaluate(env, e)
+		if err != nil {
+			return NilExpression, err
+		}
+		values = append(values, value)
+	}
+	return fromLiteral(expr, values), nil
+}
+
+// literalElems returns the forms in a collection literal in the order
+// they're evaluated: the elements of a vector or set, or each key of
+// a hash-map followed by its value.
+func literalElems(expr Expression) []Expression {
+	switch expr.tag {
+
+	case ExpVector:
+		return expr.list
+
+	case ExpSet:
+		return expr.hashMap.sortedKeys()
+
+	default:
+		elems := make([]Expression, 0, 2*expr.hashMap.size())
+		for _, key := range expr.hashMap.sortedKeys() {
+			value, _ := expr.hashMap.get(key)
+			elems = append(elems, key, value)
+		}
+		return elems
+	}
+}
+
+// fromLiteral returns a collection of the same kind as a literal
+// holding the values of its literalElems.
+func fromLiteral(expr Expression, values []Expression) Expression {
+	switch expr.tag {
+
+	case ExpVector:
+		return NewVectorExpr(values)
+
+	case ExpSet:
+		return NewSetExpr(values)
+
+	default:
+		m := newHakiMap()
+		for i := 0; i+1 < len(values); i += 2 {
+			m.set(values[i], values[i+1])
+		}
+		return NewHashMapExpr(m)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// implementations
+//-----------------------------------------------------------------------------
+
+func _keyword(args []Expression) (Expression, error) {
+	if err := typeCheck("(keyword name)", args, ckArity(1), ckString(0)); err != nil {
+		return NilExpression, err
+	}
+	return NewKeywordExpr(args[0].string), nil
+}
+
+func _keywordP(args []Expression) (Expression, error) {
+	if err := typeCheck("(keyword? val)", args, ckArity(1)); err != nil {
+		return NilExpression, err
+	}
+	return NewBoolExpr(args[0].IsKeyword()), nil
+}
+
+func _vector(args []Expression) (Expression, error) {
+	return NewVectorExpr(append([]Expression{}, args...)), nil
+}
+
+func _vectorP(args []Expression) (Expression, error) {
+	if err := typeCheck("(vector? val)", args, ckArity(1)); err != nil {
+		return NilExpression, err
+	}
+	return NewBoolExpr(args[0].IsVector()), nil
+}
+
+func _hashSet(args []Expression) (Expression, error) {
+	return NewSetExpr(args), nil
+}
+
+func _setP(args []Expression) (Expression, error) {
+	if err := typeCheck("(set? val)", args, ckArity(1)); err != nil {
+		return NilExpression, err
+	}
+	return NewBoolExpr(args[0].IsSet()), nil
+}
+
+func _containsP(args []Expression) (Expression, error) {
+	if err := typeCheck("(contains? set|hash-map val)", args,
+		ckArity(2), ckMultiType(0, ExpSet, ExpHashMap)); err != nil {
+		return NilExpression, err
+	}
+	_, found := args[0].hashMap.get(args[1])
+	return NewBoolExpr(found), nil
+}
@@ -0,0 +1,169 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errorBuiltins = primitivesMap{
+	"error":         _error,
+	"error?":        _errorP,
+	"error-message": _errorMessage,
+	"error-data":    _errorData,
+	"error-stack":   _errorStack,
+	"throw":         _throw,
+}
+
+// errorData is the value of an error expression, either made with
+// `error` or caught by `try`.
+type errorData struct {
+	message string
+	data    Expression
+	stack   []Frame // innermost call first
+}
+
+// NewErrorExpr returns an error value with a message and data.
+func NewErrorExpr(message string, data Expression) Expression {
+	return newErrorExpr(&errorData{message: message, data: data})
+}
+
+func newErrorExpr(e *errorData) Expression {
+	return Expression{
+		tag:      ExpError,
+		errorVal: e,
+	}
+}
+
+// ThrownError is returned when a script throws a value it doesn't
+// catch.
+type ThrownError struct {
+	Value Expression
+}
+
+func (e *ThrownError) Error() string {
+	if e.Value.tag == ExpError {
+		return e.Value.errorVal.message
+	}
+	return "uncaught throw: " + e.Value.String()
+}
+
+// isCatchable returns true unless err stops a script whatever it does,
+// as when it's cancelled or over a quota.
+func isCatchable(err error) bool {
+	var cancelled *CancelledError
+	var quota *QuotaExceededError
+	return !errors.As(err, &cancelled) && !errors.As(err, &quota)
+}
+
+// caught returns the error value a `catch` clause sees for err.
+func caught(err error) Expression {
+	var stack []Frame
+	message := err.Error()
+
+	var e *Error
+	if errors.As(err, &e) {
+		stack = append([]Frame{}, e.Stack...)
+		message = e.Message
+	}
+
+	var thrown *ThrownError
+	if !errors.As(err, &thrown) {
+		return newErrorExpr(&errorData{message: message, data: NilExpression, stack: stack})
+	}
+
+	value := thrown.Value
+	if value.tag != ExpError {
+		return newErrorExpr(&errorData{message: displayString(value), data: value, stack: stack})
+	}
+
+	// Rethrown errors keep the stack of where they were first thrown.
+	if len(value.errorVal.stack) > 0 {
+		return value
+	}
+	data := *value.errorVal
+	data.stack = stack
+	return newErrorExpr(&data)
+}
+
+func displayString(e Expression) string {
+	if e.IsString() {
+		return e.string
+	}
+	return e.String()
+}
+
+//-----------------------------------------------------------------------------
+// implementations
+//-----------------------------------------------------------------------------
+
+func _error(args []Expression) (Expression, error) {
+	if err := typeCheck("(error message data?)", args, ckArityRange(1, 2), ckString(0)); err != nil {
+		return NilExpression, err
+	}
+
+	data := NilExpression
+	if len(args) > 1 {
+		data = args[1]
+	}
+	return NewErrorExpr(args[0].string, data), nil
+}
+
+func _errorP(args []Expression) (Expression, error) {
+	if err := typeCheck("(error? val)", args, ckArity(1)); err != nil {
+		return NilExpression, err
+	}
+	return NewBoolExpr(args[0].tag == ExpError), nil
+}
+
+func _errorMessage(args []Expression) (Expression, error) {
+	if err := typeCheck("(error-message err)", args, ckArity(1), ckError(0)); err != nil {
+		return NilExpression, err
+	}
+	return NewStringExpr(args[0].errorVal.message), nil
+}
+
+func _errorData(args []Expression) (Expression, error) {
+	if err := typeCheck("(error-data err)", args, ckArity(1), ckError(0)); err != nil {
+		return NilExpression, err
+	}
+	return args[0].errorVal.data, nil
+}
+
+func _errorStack(args []Expression) (Expression, error) {
+	if err := typeCheck("(error-stack err)", args, ckArity(1), ckError(0)); err != nil {
+		return NilExpression, err
+	}
+
+	frames := make([]Expression, 0)
+	for _, f := range args[0].errorVal.stack {
+		if f.Call.IsValid() {
+			frames = append(frames, NewStringExpr(fmt.Sprintf("%v (%v)", f.Name, f.Call.Start)))
+		} else {
+			frames = append(frames, NewStringExpr(f.Name))
+		}
+	}
+	return NewListExpr(frames), nil
+}
+
+func _throw(args []Expression) (Expression, error) {
+	if err := typeCheck("(throw val)", args, ckArity(1)); err != nil {
+		return NilExpression, err
+	}
+	return NilExpression, &ThrownError{Value: args[0]}
+}
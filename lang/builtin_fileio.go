@@ -19,6 +19,7 @@ package lang
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -26,31 +27,30 @@ import (
 
 // Used as the payload for file-handle expressions.
 type fileData struct {
-	file    *os.File
+	file    *os.File // nil for streams supplied by the host
 	isOpen  bool
 	path    string
 	scanner *bufio.Scanner
+	owner   *session // counts the handle against its open-files quota
 }
 
-var fileioBuiltins = primitivesMap{
-	"close!":    _close,
-	"closed?":   _closedP,
-	"dir?":      _dirP,
-	"files":     _files,
-	"exists?":   _existsP,
-	"file?":     _fileP,
-	"handle?":   _handleP,
-	"open!":     _open,
-	"read-file": _readFile,
-	"read-line": _readLine,
+func fileioBuiltins(s *session) primitivesMap {
+	return primitivesMap{
+		"close!":    _close,
+		"closed?":   _closedP,
+		"dir?":      s._dirP,
+		"files":     s._files,
+		"exists?":   s._existsP,
+		"file?":     s._fileP,
+		"handle?":   _handleP,
+		"open!":     s._open,
+		"read-file": s._readFile,
+		"read-line": _readLine,
+	}
 }
 
 // NewFileHandleExpr returns a new file-handle expression.
 func NewFileHandleExpr(file *os.File) Expression {
-	data := make([]interface{}, 0)
-	data = append(data, ExpFile)
-	data = append(data, file.Name())
-
 	path, err := filepath.Abs(file.Name())
 	if err != nil {
 		path = file.Name()
@@ -63,16 +63,30 @@ func NewFileHandleExpr(file *os.File) Expression {
 		scanner: bufio.NewScanner(file),
 	}
 
-	return Expression{tag: ExpFile, hash: hashIt(data...), file: fileData}
+	return Expression{tag: ExpFile, file: fileData}
+}
+
+// newStreamHandleExpr returns a file-handle for one of an
+// interpreter's standard streams. Closing it doesn't close the
+// underlying stream, which belongs to the host.
+func newStreamHandleExpr(path string, stream io.Reader) Expression {
+	fileData := &fileData{
+		isOpen: true,
+		path:   path,
+	}
+
+	if stream != nil {
+		fileData.scanner = bufio.NewScanner(stream)
+	}
+
+	return Expression{tag: ExpFile, file: fileData}
 }
 
 //-----------------------------------------------------------------------------
 // Implementation
 //-----------------------------------------------------------------------------
 
-var _rootRegex = ""
-
-func _files(args []Expression) (Expression, error) {
+func (s *session) _files(args []Expression) (Expression, error) {
 
 	if err := typeCheck("(dirs path)", args, ckArityAtLeast(1), ckString(0), ckOptString(1)); err != nil {
 		return NilExpression, err
@@ -80,6 +94,12 @@ func _files(args []Expression) (Expression, error) {
 
 	root := args[0].string
 	pattern := "*"
+
+	absRoot, err := s.checkPath("files", root)
+	if err != nil {
+		return NilExpression, err
+	}
+
 	if len(args) == 2 {
 		pattern = args[1].string
 	}
@@ -93,7 +113,12 @@ func _files(args []Expression) (Expression, error) {
 
 		ok, err := filepath.Match(pattern, info.Name())
 		if ok {
-			matches = append(matches, path)
+			// Report paths relative to the root as the script wrote it.
+			rel, err := filepath.Rel(absRoot, path)
+			if err != nil {
+				return err
+			}
+			matches = append(matches, filepath.Join(root, rel))
 		}
 		if err != nil {
 			return err
@@ -101,7 +126,7 @@ func _files(args []Expression) (Expression, error) {
 		return nil
 	}
 
-	if err := filepath.Walk(root, walker); err != nil {
+	if err := filepath.Walk(absRoot, walker); err != nil {
 		return NilExpression, err
 	}
 
@@ -132,9 +157,7 @@ func _readLine(args []Expression) (Expression, error) {
 	if !moreToScan {
 		err := fileData.scanner.Err()
 		if err == nil {
-			fileData.file.Close()
-			fileData.isOpen = false
-			fileData.scanner = nil
+			fileData.close()
 			return NilExpression, nil
 		}
 
@@ -145,7 +168,7 @@ func _readLine(args []Expression) (Expression, error) {
 	return NewStringExpr(line), nil
 }
 
-func _readFile(args []Expression) (Expression, error) {
+func (s *session) _readFile(args []Expression) (Expression, error) {
 	sig := "(read-file fpath)"
 	specs := []spec{ckArity(1), ckString(0)}
 
@@ -153,7 +176,12 @@ func _readFile(args []Expression) (Expression, error) {
 		return NilExpression, err
 	}
 
-	buffer, err := ioutil.ReadFile(args[0].string)
+	path, err := s.checkPath("read-file", args[0].string)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	buffer, err := ioutil.ReadFile(path)
 	if err != nil {
 		return NilExpression, err
 	}
@@ -162,7 +190,7 @@ func _readFile(args []Expression) (Expression, error) {
 	return NewExpr(ExpString, str), nil
 }
 
-func _open(args []Expression) (Expression, error) {
+func (s *session) _open(args []Expression) (Expression, error) {
 	sig := "(open fpath)"
 	specs := []spec{ckArity(1), ckString(0)}
 
@@ -170,13 +198,24 @@ func _open(args []Expression) (Expression, error) {
 		return NilExpression, err
 	}
 
-	path := args[0].string
+	path, err := s.checkPath("open!", args[0].string)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	if err := s.openFile(); err != nil {
+		return NilExpression, err
+	}
+
 	file, err := os.Open(path)
 	if err != nil {
+		s.closeFile()
 		return NilExpression, err
 	}
 
-	return NewFileHandleExpr(file), nil
+	handle := NewFileHandleExpr(file)
+	handle.file.owner = s
+	return handle, nil
 }
 
 func safeClose(f *os.File) error {
@@ -190,6 +229,19 @@ func safeClose(f *os.File) error {
 	return nil
 }
 
+// close marks the handle closed, releasing its quota reservation.
+func (fd *fileData) close() error {
+	if fd.isOpen && fd.owner != nil {
+		fd.owner.closeFile()
+	}
+	fd.isOpen = false
+	fd.scanner = nil
+	if fd.file == nil {
+		return nil
+	}
+	return safeClose(fd.file)
+}
+
 func _close(args []Expression) (Expression, error) {
 	sig := "(close fhandle)"
 	specs := []spec{ckArity(1), ckHandle(0)}
@@ -198,10 +250,7 @@ func _close(args []Expression) (Expression, error) {
 		return NilExpression, err
 	}
 
-	fileData := args[0].file
-	fileData.isOpen = false
-	fileData.scanner = nil
-	if err := safeClose(fileData.file); err != nil {
+	if err := args[0].file.close(); err != nil {
 		return NilExpression, err
 	}
 	return NilExpression, nil
@@ -220,7 +269,7 @@ func _closedP(args []Expression) (Expression, error) {
 	return NewBoolExpr(!fileData.isOpen), nil
 }
 
-func _dirP(args []Expression) (Expression, error) {
+func (s *session) _dirP(args []Expression) (Expression, error) {
 	sig := "(dir? fpath)"
 	specs := []spec{ckArity(1), ckString(0)}
 
@@ -228,7 +277,10 @@ func _dirP(args []Expression) (Expression, error) {
 		return NilExpression, err
 	}
 
-	path := args[0].string
+	path, err := s.checkPath("dir?", args[0].string)
+	if err != nil {
+		return NilExpression, err
+	}
 
 	f, err := os.Open(path)
 	if err != nil {
@@ -245,7 +297,7 @@ func _dirP(args []Expression) (Expression, error) {
 	return NewExpr(ExpBool, info.IsDir()), nil
 }
 
-func _existsP(args []Expression) (Expression, error) {
+func (s *session) _existsP(args []Expression) (Expression, error) {
 	sig := "(exists? fpath)"
 	specs := []spec{ckArity(1), ckString(0)}
 
@@ -253,7 +305,10 @@ func _existsP(args []Expression) (Expression, error) {
 		return NilExpression, err
 	}
 
-	path := args[0].string
+	path, err := s.checkPath("exists?", args[0].string)
+	if err != nil {
+		return NilExpression, err
+	}
 
 	if _, err := os.Stat(path); !os.IsNotExist(err) {
 		return TrueExpression, nil
@@ -261,7 +316,7 @@ func _existsP(args []Expression) (Expression, error) {
 	return FalseExpression, nil
 }
 
-func _fileP(args []Expression) (Expression, error) {
+func (s *session) _fileP(args []Expression) (Expression, error) {
 	sig := "(file? fpath)"
 	specs := []spec{ckArity(1), ckString(0)}
 
@@ -269,7 +324,10 @@ func _fileP(args []Expression) (Expression, error) {
 		return NilExpression, err
 	}
 
-	path := args[0].string
+	path, err := s.checkPath("file?", args[0].string)
+	if err != nil {
+		return NilExpression, err
+	}
 
 	f, err := os.Open(path)
 	if err != nil {
@@ -34,77 +34,166 @@ var hashmapBuiltins = primitivesMap{
 	"hset-in": _hsetin,
 }
 
-// HakiHashMap represents a hash-map type in the Haki language.
+// HakiHashMap represents a hash-map type in the Haki language. It's
+// persistent: changes are made to copies, which share what's unchanged
+// with the original (see hamt.go).
 type HakiHashMap struct {
-	keys map[uint32]Expression
-	vals map[uint32]Expression
+	root  *hamtNode
+	count int
 }
 
 func newHakiMap() *HakiHashMap {
-	return &HakiHashMap{
-		keys: make(map[uint32]Expression),
-		vals: make(map[uint32]Expression),
-	}
+	return &HakiHashMap{}
 }
 
+// set binds key to value, or removes key if value is nil. Only maps
+// not yet in an expression are changed, and their nodes are copied
+// rather than changed, as other maps may share them.
 func (hmap *HakiHashMap) set(key, value Expression) {
-	if value.Equals(NilExpression) {
-		delete(hmap.keys, key.hash)
-		delete(hmap.vals, key.hash)
+	if !value.Equals(NilExpression) {
+		hmap.put(key, value)
 		return
 	}
-	hmap.keys[key.hash] = key
-	hmap.vals[key.hash] = value
+
+	var old *hamtEntry
+	if hmap.root, old = hmap.root.without(key, key.hashCode(), 0); old != nil {
+		hmap.count--
+	}
 }
 
-func (hmap *HakiHashMap) copy() *HakiHashMap {
-	newMap := newHakiMap()
-	for lookup := range hmap.keys {
-		val := hmap.vals[lookup]
-		if val.tag == ExpHashMap {
-			val = NewHashMapExpr(val.hashMap.copy())
-		}
-		newMap.set(hmap.keys[lookup], val)
+// put binds key to value, even if it's nil, as in a literal's forms.
+func (hmap *HakiHashMap) put(key, value Expression) {
+	entry := &hamtEntry{key: key, value: value, hash: key.hashCode()}
+	root, old := hmap.root.with(entry, 0)
+	hmap.root = root
+	hmap.count++
+	if old != nil {
+		hmap.count--
 	}
-	return newMap
 }
 
-func (hmap *HakiHashMap) isEmpty() bool {
-	return len(hmap.keys) == 0
+// with returns a copy of the map with key bound to value, or without
+// key if value is nil.
+func (hmap *HakiHashMap) with(key, value Expression) *HakiHashMap {
+	m := *hmap
+	m.set(key, value)
+	return &m
 }
 
-func (hmap *HakiHashMap) String() string {
-	sections := make([]string, 0)
-	for hash, key := range hmap.keys {
-		sections = append(sections, fmt.Sprintf("%v: %v", key, hmap.vals[hash]))
+// get returns the value bound to key, or nil.
+func (hmap *HakiHashMap) get(key Expression) (Expression, bool) {
+	if entry := hmap.root.find(key, key.hashCode()); entry != nil {
+		return entry.value, true
 	}
+	return NilExpression, false
+}
 
-	return "(hmap " + strings.Join(sections, ", ") + ")"
+func (hmap *HakiHashMap) size() int {
+	return hmap.count
 }
 
-// NewHashMapExpr returns an expression wrapper around a hash map
-func NewHashMapExpr(hmap *HakiHashMap) Expression {
-	data := make([]interface{}, 0)
-	data = append(data, ExpHashMap)
+func (hmap *HakiHashMap) isEmpty() bool {
+	return hmap.count == 0
+}
 
-	keys := make([]int, 0)
-	for k := range hmap.keys {
-		keys = append(keys, int(k))
+// entries returns the map's entries.
+func (hmap *HakiHashMap) entries() []*hamtEntry {
+	entries := make([]*hamtEntry, 0, hmap.count)
+	hmap.root.each(func(e *hamtEntry) {
+		entries = append(entries, e)
+	})
+	return entries
+}
+
+// sameEntries is true if both maps bind equal keys to equal values.
+func (hmap *HakiHashMap) sameEntries(other *HakiHashMap) bool {
+	if hmap.count != other.count {
+		return false
 	}
+	same := true
+	hmap.root.each(func(e *hamtEntry) {
+		if !same {
+			return
+		}
+		found := other.root.find(e.key, e.hash)
+		same = found != nil && found.value.Equals(e.value)
+	})
+	return same
+}
 
-	sort.Ints(keys)
+// hashCode returns the hash of the map's entries. It's their sum, so it
+// doesn't depend on the order they were added in.
+func (hmap *HakiHashMap) hashCode(seed uint32) uint32 {
+	sum := uint32(0)
+	hmap.root.each(func(e *hamtEntry) {
+		sum += mixHash(e.hash, e.value.hashCode())
+	})
+	return finishHash(seed + sum)
+}
+
+// each calls fn with every key and its value.
+func (hmap *HakiHashMap) each(fn func(key, value Expression)) {
+	hmap.root.each(func(e *hamtEntry) {
+		fn(e.key, e.value)
+	})
+}
 
-	for sortedKey := range keys {
-		data = append(data, hmap.vals[uint32(sortedKey)])
+func (hmap *HakiHashMap) String() string {
+	sections := make([]string, 0, hmap.count)
+	for _, key := range hmap.sortedKeys() {
+		value, _ := hmap.get(key)
+		sections = append(sections, key.String()+" "+value.String())
 	}
 
+	return "{" + strings.Join(sections, " ") + "}"
+}
+
+// sortedKeys returns the keys in order of their printed form.
+func (hmap *HakiHashMap) sortedKeys() []Expression {
+	keys := make([]Expression, 0, hmap.count)
+	hmap.each(func(key, _ Expression) {
+		keys = append(keys, key)
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+// NewHashMapExpr returns an expression wrapper around a hash map
+func NewHashMapExpr(hmap *HakiHashMap) Expression {
 	return Expression{
 		tag:     ExpHashMap,
-		hash:    hashIt(data...),
 		hashMap: hmap,
+		cached:  newHashCell(),
 	}
 }
 
+// setIn returns a copy of the map with the value at the end of a path
+// of keys replaced, making maps for the keys that aren't bound.
+func (hmap *HakiHashMap) setIn(path []Expression, value Expression) (*HakiHashMap, error) {
+	key := path[0]
+	if len(path) == 1 {
+		return hmap.with(key, value), nil
+	}
+
+	sub := newHakiMap()
+	place, _ := hmap.get(key)
+	switch place.tag {
+	case ExpHashMap:
+		sub = place.hashMap
+	case ExpNil:
+	default:
+		return nil, fmt.Errorf("'%v' key reached non-hashmap value of type '%v'", key, ExprTypeName(place.tag))
+	}
+
+	sub, err := sub.setIn(path[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	return hmap.with(key, NewHashMapExpr(sub)), nil
+}
+
 //-----------------------------------------------------------------------------
 // implementations
 //-----------------------------------------------------------------------------
@@ -148,11 +237,10 @@ func _hsets(args []Expression) (Expression, error) {
 		return NewListExpr([]Expression{}), nil
 	}
 
-	pairs := make([]Expression, 0)
-	for k, v := range hm.keys {
-		pair := NewListExpr([]Expression{v, hm.vals[k]})
-		pairs = append(pairs, pair)
-	}
+	pairs := make([]Expression, 0, hm.size())
+	hm.each(func(k, v Expression) {
+		pairs = append(pairs, NewListExpr([]Expression{k, v}))
+	})
 
 	return NewListExpr(pairs), nil
 }
@@ -170,10 +258,10 @@ func _hkeys(args []Expression) (Expression, error) {
 			ExprTypeName(args[0].tag))
 	}
 
-	exprs := make([]Expression, 0)
-	for _, v := range args[0].hashMap.keys {
-		exprs = append(exprs, v)
-	}
+	exprs := make([]Expression, 0, args[0].hashMap.size())
+	args[0].hashMap.each(func(k, _ Expression) {
+		exprs = append(exprs, k)
+	})
 
 	return NewExpr(ExpList, exprs), nil
 }
@@ -191,10 +279,10 @@ func _hvals(args []Expression) (Expression, error) {
 			ExprTypeName(args[0].tag))
 	}
 
-	exprs := make([]Expression, 0)
-	for _, v := range args[0].hashMap.vals {
+	exprs := make([]Expression, 0, args[0].hashMap.size())
+	args[0].hashMap.each(func(_, v Expression) {
 		exprs = append(exprs, v)
-	}
+	})
 
 	return NewExpr(ExpList, exprs), nil
 }
@@ -207,10 +295,8 @@ func _hget(args []Expression) (Expression, error) {
 		return NilExpression, err
 	}
 
-	h := args[0].hashMap
-	key := args[1].hash
-
-	return h.vals[key], nil
+	value, _ := args[0].hashMap.get(args[1])
+	return value, nil
 }
 
 func _hset(args []Expression) (Expression, error) {
@@ -234,7 +320,7 @@ func _hset(args []Expression) (Expression, error) {
 			len(keyValues))
 	}
 
-	newMap := original.hashMap.copy()
+	newMap := *original.hashMap
 
 	for i := 0; i < len(keyValues); i += 2 {
 		key := keyValues[i]
@@ -242,7 +328,7 @@ func _hset(args []Expression) (Expression, error) {
 		newMap.set(key, value)
 	}
 
-	return NewHashMapExpr(newMap), nil
+	return NewHashMapExpr(&newMap), nil
 }
 
 func _hgetin(args []Expression) (Expression, error) {
@@ -257,7 +343,7 @@ func _hgetin(args []Expression) (Expression, error) {
 
 	for _, k := range args[1].list {
 		if m.tag == ExpHashMap {
-			m = m.hashMap.vals[k.hash]
+			m, _ = m.hashMap.get(k)
 			continue
 		}
 		return NilExpression, nil
@@ -275,46 +361,13 @@ func _hsetin(args []Expression) (Expression, error) {
 	}
 
 	pathKeys := args[1].list
-	lastKey := pathKeys[len(pathKeys)-1]
-	newVal := args[2]
-
-	original := args[0]
-	newMap := original.hashMap.copy()
-	curMap := newMap
-
-	for _, k := range pathKeys {
-		place := curMap.vals[k.hash]
-
-		if k.Equals(lastKey) {
-			curMap.set(k, newVal)
-			break
-		}
-
-		if place.tag == ExpHashMap && k.Equals(lastKey) {
-			place.hashMap.set(k, newVal)
-			break
-		}
-
-		if place.tag == ExpHashMap {
-			curMap = place.hashMap
-			continue
-		}
-
-		if place.Equals(NilExpression) && k.Equals(lastKey) {
-			curMap.set(k, newVal)
-			continue
-		}
-
-		if place.Equals(NilExpression) {
-			subMap := newHakiMap()
-			curMap.set(k, NewHashMapExpr(subMap))
-			curMap = subMap
-			continue
-		}
-
-		return NilExpression,
-			fmt.Errorf("'%v' key reached non-hashmap value of type '%v'", k, ExprTypeName(place.tag))
+	if len(pathKeys) == 0 {
+		return nilExpr("%v expects at least one key", sig)
 	}
 
+	newMap, err := args[0].hashMap.setIn(pathKeys, args[2])
+	if err != nil {
+		return NilExpression, err
+	}
 	return NewHashMapExpr(newMap), nil
 }
@@ -20,7 +20,7 @@ import (
 	"errors"
 )
 
-var listBuiltins = map[string]primitiveFunc{
+var listBuiltins = map[string]PrimitiveFunc{
 	"append":   _append,
 	"count":    _count,
 	"head":     _head,
@@ -49,15 +49,7 @@ var CoreListFunctions = `
 
 // NewListExpr constructs a new list
 func NewListExpr(list []Expression) Expression {
-
-	data := make([]interface{}, 0)
-	data = append(data, ExpList)
-
-	for _, e := range list {
-		data = append(data, e.hash)
-	}
-
-	return Expression{tag: ExpList, hash: hashIt(data...), list: list}
+	return Expression{tag: ExpList, list: list, cached: newHashCell()}
 }
 
 // NewStringListExpr is a convenience function to turn a string array into a Haki list
@@ -79,7 +71,7 @@ func NewStringListExpr(args []string) Expression {
 //-----------------------------------------------------------------------------
 
 func _nth(args []Expression) (Expression, error) {
-	if err := typeCheck("(nth lst index)", args, ckArity(2), ckList(0), ckInt(1)); err != nil {
+	if err := typeCheck("(nth lst index)", args, ckArity(2), ckSequence(0), ckInt(1)); err != nil {
 		return NilExpression, err
 	}
 
@@ -110,7 +102,7 @@ func _nthTail(args []Expression) (Expression, error) {
 
 func _count(args []Expression) (Expression, error) {
 
-	if err := typeCheck("(count string|list|hash-map)", args,
+	if err := typeCheck("(count string|list|vector|set|hash-map)", args,
 		ckArity(1), ckCountable(0)); err != nil {
 		return NilExpression, err
 	}
@@ -118,10 +110,10 @@ func _count(args []Expression) (Expression, error) {
 	e := args[0]
 	var c int
 
-	if e.IsList() {
+	if e.IsList() || e.IsVector() {
 		c = len(e.list)
-	} else if e.IsHashMap() {
-		c = len(e.hashMap.keys)
+	} else if e.IsHashMap() || e.IsSet() {
+		c = e.hashMap.size()
 	} else {
 		c = len(e.string)
 	}
@@ -160,7 +152,7 @@ func _prepend(args []Expression) (Expression, error) {
 		)
 	}
 
-	return NewExpr(ExpList, append([]Expression{item}, list.list...)), nil
+	return prependItem(item, list), nil
 }
 
 // (append list x)
@@ -176,7 +168,7 @@ func _append(args []Expression) (Expression, error) {
 		return nilExpr("append's first arg (list, item) must be a list")
 	}
 
-	return NewExpr(ExpList, append(list.list, item)), nil
+	return appendItem(list, item), nil
 }
 
 // (join list1 list2 ... listn)
@@ -201,7 +193,7 @@ func _head(args []Expression) (Expression, error) {
 		return NilExpression, errors.New("head requires a parameter")
 	}
 
-	if !args[0].IsList() {
+	if !args[0].IsList() && !args[0].IsVector() {
 		return NilExpression, errors.New("head requires a list parameter")
 	}
 
@@ -218,7 +210,7 @@ func _tail(args []Expression) (Expression, error) {
 		return NilExpression, errors.New("tail requires a parameter")
 	}
 
-	if !args[0].IsList() {
+	if !args[0].IsList() && !args[0].IsVector() {
 		return NilExpression, errors.New("tail requires a list parameter")
 	}
 
@@ -227,5 +219,8 @@ func _tail(args []Expression) (Expression, error) {
 	if len(list) == 0 {
 		return NilExpression, nil
 	}
+	if args[0].IsList() {
+		return tailOf(args[0]), nil
+	}
 	return NewExpr(ExpList, list[1:]), nil
 }
@@ -17,171 +17,443 @@
 package lang
 
 import (
-	"errors"
+	"fmt"
 	"math"
+	"math/big"
 )
 
+// Numbers are integers (int64) or floats (float64). Arithmetic on
+// integers gives an exact integer, or an error if it overflows, and
+// arithmetic involving a float gives a float. Float results that
+// aren't finite (NaN or ±Inf) are errors.
+
 var mathBuiltins = primitivesMap{
-	"*":   _mult,
-	"+":   _add,
-	"-":   _minus,
-	"<":   _lessThan,
-	"mod": _mod,
+	"*":        _mult,
+	"+":        _add,
+	"-":        _minus,
+	"/":        _divide,
+	"<":        _lessThan,
+	"<=":       _lessEqual,
+	">":        _greaterThan,
+	">=":       _greaterEqual,
+	"==":       _numEquals,
+	"abs":      _abs,
+	"ceil":     _ceil,
+	"exp":      _exp,
+	"float":    _float,
+	"float?":   _floatP,
+	"floor":    _floor,
+	"integer?": _integerP,
+	"log":      _log,
+	"max":      _max,
+	"min":      _min,
+	"mod":      _mod,
+	"number?":  _numberP,
+	"pow":      _pow,
+	"quot":     _quot,
+	"rem":      _rem,
+	"round":    _round,
+	"sqrt":     _sqrt,
 }
 
-func isIntegral(val float64) bool {
-	return val == float64(int64(val))
+func asFloat(e Expression) float64 {
+	if e.tag == ExpInteger {
+		return float64(e.integer)
+	}
+	return e.float
 }
 
-func toExpr(x float64) Expression {
-	if isIntegral(x) {
-		return NewExpr(ExpInteger, int64(x))
+// finite returns f as a float, or an error if it isn't finite.
+func finite(sig string, f float64, args []Expression) (Expression, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nilExpr("%v «-- no finite result for %v", sig, printList(args))
 	}
-	return NewExpr(ExpFloat, x)
+	return NewFloatExpr(f), nil
 }
 
-func asNumber(expr Expression) (float64, error) {
-	switch expr.tag {
-	case ExpInteger:
-		return float64(expr.integer), nil
-	case ExpFloat:
-		return expr.float, nil
-	default:
-		return 0, errors.New("not a number")
+// toInt returns f as an integer, or an error if it's out of range.
+func toInt(sig string, f float64) (Expression, error) {
+	if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return nilExpr("%v «-- %v is out of integer range", sig, printFloat(f))
 	}
+	return NewIntExpr(int64(f)), nil
 }
 
-func _mult(args []Expression) (Expression, error) {
-	if err := verifyNums(args); err != nil {
-		return NilExpression, err
+func overflow(sig string, args []Expression) (Expression, error) {
+	return nilExpr("%v «-- integer overflow for %v", sig, printList(args))
+}
+
+//-----------------------------------------------------------------------------
+// Checked integer operations
+//-----------------------------------------------------------------------------
+
+func addInt(a, b int64) (int64, bool) {
+	c := a + b
+	return c, (a^c)&(b^c) >= 0
+}
+
+func subInt(a, b int64) (int64, bool) {
+	c := a - b
+	return c, (a^b)&(a^c) >= 0
+}
+
+func mulInt(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) || c/b != a {
+		return c, false
+	}
+	return c, true
+}
+
+func powInt(base, exp int64) (int64, bool) {
+	result := int64(1)
+	for ; exp > 0; exp >>= 1 {
+		var ok bool
+		if exp&1 == 1 {
+			if result, ok = mulInt(result, base); !ok {
+				return 0, false
+			}
+		}
+		if exp > 1 {
+			if base, ok = mulInt(base, base); !ok {
+				return 0, false
+			}
+		}
 	}
-	result := 1.0
-	for _, arg := range args {
-		num, err := asNumber(arg)
+	return result, true
+}
+
+// arith folds operands from left to right, with intOp while the
+// result is an integer, and floatOp once a float is involved. Errors
+// report args, the numbers the function was called with, as operands
+// may start with an identity such as 0.
+func arith(sig string, args, operands []Expression, intOp func(a, b int64) (int64, bool),
+	floatOp func(a, b float64) float64) (Expression, error) {
+
+	result := operands[0]
+	for _, arg := range operands[1:] {
+		if result.tag == ExpInteger && arg.tag == ExpInteger {
+			value, ok := intOp(result.integer, arg.integer)
+			if !ok {
+				return overflow(sig, args)
+			}
+			result = NewIntExpr(value)
+			continue
+		}
+
+		var err error
+		result, err = finite(sig, floatOp(asFloat(result), asFloat(arg)), args)
 		if err != nil {
 			return NilExpression, err
 		}
-		result = result * num
 	}
-	return toExpr(result), nil
+	return result, nil
 }
 
-func _lessThan(args []Expression) (Expression, error) {
+//-----------------------------------------------------------------------------
+// Comparison
+//-----------------------------------------------------------------------------
 
-	argc := len(args)
+// compareNums returns -1, 0 or 1 as a is less than, equal to or
+// greater than b, comparing integers with floats exactly. It returns
+// false if either is NaN.
+func compareNums(a, b Expression) (int, bool) {
+	switch {
+	case a.tag == ExpInteger && b.tag == ExpInteger:
+		switch {
+		case a.integer < b.integer:
+			return -1, true
+		case a.integer > b.integer:
+			return 1, true
+		}
+		return 0, true
+	case math.IsNaN(asFloat(a)) || math.IsNaN(asFloat(b)):
+		return 0, false
+	}
+	return bigFloat(a).Cmp(bigFloat(b)), true
+}
 
-	if argc < 1 {
-		return nilExpr("(< a b ... n) requires at least 1 arg")
+func bigFloat(e Expression) *big.Float {
+	if e.tag == ExpInteger {
+		return new(big.Float).SetInt64(e.integer)
 	}
+	return big.NewFloat(e.float)
+}
 
-	if err := verifyNums(args); err != nil {
+// chain returns true if test holds for each number and the one to
+// its right.
+func chain(sig string, args []Expression, test func(c int) bool) (Expression, error) {
+	if err := typeCheck(sig, args, ckArityAtLeast(1), ckNumbers()); err != nil {
 		return NilExpression, err
 	}
+	for i := 1; i < len(args); i++ {
+		c, ok := compareNums(args[i-1], args[i])
+		if !ok || !test(c) {
+			return FalseExpression, nil
+		}
+	}
+	return TrueExpression, nil
+}
 
-	sentinel, err := asNumber(args[0])
-	if err != nil {
+//-----------------------------------------------------------------------------
+// implementations
+//-----------------------------------------------------------------------------
+
+func _add(args []Expression) (Expression, error) {
+	sig := "(+ num…)"
+	if err := typeCheck(sig, args, ckNumbers()); err != nil {
 		return NilExpression, err
 	}
+	return arith(sig, args, append([]Expression{NewIntExpr(0)}, args...), addInt,
+		func(a, b float64) float64 { return a + b })
+}
 
-	for _, arg := range args[1:] {
-		candidate, err := asNumber(arg)
+func _minus(args []Expression) (Expression, error) {
+	sig := "(- num…)"
+	if err := typeCheck(sig, args, ckArityAtLeast(1), ckNumbers()); err != nil {
+		return NilExpression, err
+	}
+	operands := args
+	if len(args) == 1 {
+		operands = []Expression{NewIntExpr(0), args[0]}
+	}
+	return arith(sig, args, operands, subInt, func(a, b float64) float64 { return a - b })
+}
+
+func _mult(args []Expression) (Expression, error) {
+	sig := "(* num…)"
+	if err := typeCheck(sig, args, ckNumbers()); err != nil {
+		return NilExpression, err
+	}
+	return arith(sig, args, append([]Expression{NewIntExpr(1)}, args...), mulInt,
+		func(a, b float64) float64 { return a * b })
+}
+
+// _divide gives an integer when integers divide exactly, and a float
+// otherwise.
+func _divide(args []Expression) (Expression, error) {
+	sig := "(/ num…)"
+	if err := typeCheck(sig, args, ckArityAtLeast(1), ckNumbers()); err != nil {
+		return NilExpression, err
+	}
+	operands := args
+	if len(args) == 1 {
+		operands = []Expression{NewIntExpr(1), args[0]}
+	}
+
+	result := operands[0]
+	for _, arg := range operands[1:] {
+		if asFloat(arg) == 0 {
+			return nilExpr("%v «-- divide by zero", sig)
+		}
+
+		if result.tag == ExpInteger && arg.tag == ExpInteger && result.integer%arg.integer == 0 {
+			if result.integer == math.MinInt64 && arg.integer == -1 {
+				return overflow(sig, args)
+			}
+			result = NewIntExpr(result.integer / arg.integer)
+			continue
+		}
+
+		var err error
+		result, err = finite(sig, asFloat(result)/asFloat(arg), args)
 		if err != nil {
 			return NilExpression, err
 		}
-		if candidate <= sentinel {
-			return FalseExpression, nil
-		}
-		sentinel = candidate
 	}
-
-	return TrueExpression, nil
+	return result, nil
 }
 
-func _mod(args []Expression) (Expression, error) {
-	argc := len(args)
-	if argc != 2 {
-		return nilExpr("(mod num div) takes 2 args, you provided %v", argc)
+// intDivision checks the args of quot, rem and mod.
+func intDivision(sig string, args []Expression) error {
+	if err := typeCheck(sig, args, ckArity(2), ckInt(0, 1)); err != nil {
+		return err
+	}
+	if args[1].integer == 0 {
+		return fmt.Errorf("%v «-- divide by zero", sig)
 	}
+	return nil
+}
 
-	num := args[0]
-	div := args[1]
+func _quot(args []Expression) (Expression, error) {
+	sig := "(quot num div)"
+	if err := intDivision(sig, args); err != nil {
+		return NilExpression, err
+	}
+	if args[0].integer == math.MinInt64 && args[1].integer == -1 {
+		return overflow(sig, args)
+	}
+	return NewIntExpr(args[0].integer / args[1].integer), nil
+}
 
-	n := num.float
-	d := div.float
+func _rem(args []Expression) (Expression, error) {
+	sig := "(rem num div)"
+	if err := intDivision(sig, args); err != nil {
+		return NilExpression, err
+	}
+	return NewIntExpr(args[0].integer % args[1].integer), nil
+}
 
-	if num.tag == ExpInteger {
-		n = float64(num.integer)
+// _mod is the remainder of floored division, so it has the sign of
+// div.
+func _mod(args []Expression) (Expression, error) {
+	sig := "(mod num div)"
+	if err := intDivision(sig, args); err != nil {
+		return NilExpression, err
 	}
-	if div.tag == ExpInteger {
-		d = float64(div.integer)
+	m := args[0].integer % args[1].integer
+	if m != 0 && (m < 0) != (args[1].integer < 0) {
+		m += args[1].integer
 	}
+	return NewIntExpr(m), nil
+}
+
+func _lessThan(args []Expression) (Expression, error) {
+	return chain("(< num…)", args, func(c int) bool { return c < 0 })
+}
+
+func _lessEqual(args []Expression) (Expression, error) {
+	return chain("(<= num…)", args, func(c int) bool { return c <= 0 })
+}
 
-	result := math.Mod(n, d)
+func _greaterThan(args []Expression) (Expression, error) {
+	return chain("(> num…)", args, func(c int) bool { return c > 0 })
+}
+
+func _greaterEqual(args []Expression) (Expression, error) {
+	return chain("(>= num…)", args, func(c int) bool { return c >= 0 })
+}
+
+func _numEquals(args []Expression) (Expression, error) {
+	return chain("(== num…)", args, func(c int) bool { return c == 0 })
+}
 
-	if isIntegral(result) {
-		return NewExpr(ExpInteger, int64(result)), nil
+func _abs(args []Expression) (Expression, error) {
+	sig := "(abs num)"
+	if err := typeCheck(sig, args, ckArity(1), ckNumber(0)); err != nil {
+		return NilExpression, err
+	}
+	if args[0].tag == ExpFloat {
+		return NewFloatExpr(math.Abs(args[0].float)), nil
+	}
+	if args[0].integer == math.MinInt64 {
+		return overflow(sig, args)
 	}
-	return NewExpr(ExpFloat, result), nil
+	if args[0].integer < 0 {
+		return NewIntExpr(-args[0].integer), nil
+	}
+	return args[0], nil
 }
 
-func _add(args []Expression) (Expression, error) {
-	var result float64
-	for _, arg := range args {
-		switch arg.tag {
-		case ExpFloat:
-			result = result + float64(arg.float)
-		case ExpInteger:
-			result = result + float64(arg.integer)
-		default:
-			return nilExpr("unknown argument type for [%v], [int/float] expected, got [%v]", arg, arg.Type())
+// extreme returns the arg for which better is true against every
+// other arg.
+func extreme(sig string, args []Expression, better func(c int) bool) (Expression, error) {
+	if err := typeCheck(sig, args, ckArityAtLeast(1), ckNumbers()); err != nil {
+		return NilExpression, err
+	}
+	result := args[0]
+	for _, arg := range args[1:] {
+		if c, ok := compareNums(arg, result); ok && better(c) {
+			result = arg
 		}
 	}
+	return result, nil
+}
 
-	if isIntegral(result) {
-		return NewExpr(ExpInteger, int64(result)), nil
-	}
-	return NewExpr(ExpFloat, result), nil
+func _min(args []Expression) (Expression, error) {
+	return extreme("(min num…)", args, func(c int) bool { return c < 0 })
 }
 
-func _minus(args []Expression) (Expression, error) {
+func _max(args []Expression) (Expression, error) {
+	return extreme("(max num…)", args, func(c int) bool { return c > 0 })
+}
 
-	if len(args) < 1 {
-		return NilExpression, errors.New("`-` requires 1 or more args")
+// rounding returns an integer arg as is, and rounds a float one to
+// an integer.
+func rounding(sig string, args []Expression, round func(float64) float64) (Expression, error) {
+	if err := typeCheck(sig, args, ckArity(1), ckNumber(0)); err != nil {
+		return NilExpression, err
 	}
+	if args[0].tag == ExpInteger {
+		return args[0], nil
+	}
+	return toInt(sig, round(args[0].float))
+}
+
+func _floor(args []Expression) (Expression, error) {
+	return rounding("(floor num)", args, math.Floor)
+}
+
+func _ceil(args []Expression) (Expression, error) {
+	return rounding("(ceil num)", args, math.Ceil)
+}
 
-	var result float64
+func _round(args []Expression) (Expression, error) {
+	return rounding("(round num)", args, math.Round)
+}
 
-	switch args[0].tag {
-	case ExpFloat:
-		result = float64(args[0].float)
-	case ExpInteger:
-		result = float64(args[0].integer)
-	default:
-		return nilExpr("In '-', unknown argument type [%v], [int/float] expected, got [%v]", args[0], args[0].Type())
+// unary applies a float function to a number.
+func unary(sig string, args []Expression, fn func(float64) float64) (Expression, error) {
+	if err := typeCheck(sig, args, ckArity(1), ckNumber(0)); err != nil {
+		return NilExpression, err
 	}
+	return finite(sig, fn(asFloat(args[0])), args)
+}
 
-	if len(args) == 1 {
-		result = -1.0 * result
+func _sqrt(args []Expression) (Expression, error) {
+	return unary("(sqrt num)", args, math.Sqrt)
+}
+
+func _exp(args []Expression) (Expression, error) {
+	return unary("(exp num)", args, math.Exp)
+}
 
-		if isIntegral(result) {
-			return NewExpr(ExpInteger, int64(result)), nil
+func _log(args []Expression) (Expression, error) {
+	return unary("(log num)", args, math.Log)
+}
+
+func _float(args []Expression) (Expression, error) {
+	return unary("(float num)", args, func(f float64) float64 { return f })
+}
+
+// _pow gives an exact integer for an integer raised to a non-negative
+// integer, and a float otherwise.
+func _pow(args []Expression) (Expression, error) {
+	sig := "(pow base exp)"
+	if err := typeCheck(sig, args, ckArity(2), ckNumber(0, 1)); err != nil {
+		return NilExpression, err
+	}
+	base, exp := args[0], args[1]
+	if base.tag == ExpInteger && exp.tag == ExpInteger && exp.integer >= 0 {
+		result, ok := powInt(base.integer, exp.integer)
+		if !ok {
+			return overflow(sig, args)
 		}
-		return NewExpr(ExpFloat, result), nil
+		return NewIntExpr(result), nil
 	}
+	return finite(sig, math.Pow(asFloat(base), asFloat(exp)), args)
+}
 
-	for _, arg := range args[1:] {
-		switch arg.tag {
-		case ExpFloat:
-			result = result - float64(arg.float)
-		case ExpInteger:
-			result = result - float64(arg.integer)
-		default:
-			return nilExpr("In '-', unknown argument type [%v], [int/float] expected, got [%v]", arg, arg.Type())
-		}
+func _numberP(args []Expression) (Expression, error) {
+	if err := typeCheck("(number? val)", args, ckArity(1)); err != nil {
+		return NilExpression, err
 	}
+	return NewBoolExpr(args[0].tag == ExpInteger || args[0].tag == ExpFloat), nil
+}
 
-	if isIntegral(result) {
-		return NewExpr(ExpInteger, int64(result)), nil
+func _integerP(args []Expression) (Expression, error) {
+	if err := typeCheck("(integer? val)", args, ckArity(1)); err != nil {
+		return NilExpression, err
+	}
+	return NewBoolExpr(args[0].tag == ExpInteger), nil
+}
+
+func _floatP(args []Expression) (Expression, error) {
+	if err := typeCheck("(float? val)", args, ckArity(1)); err != nil {
+		return NilExpression, err
 	}
-	return NewExpr(ExpFloat, result), nil
+	return NewBoolExpr(args[0].tag == ExpFloat), nil
 }
@@ -23,15 +23,32 @@ import (
 	"strings"
 )
 
-var osBuiltins = primitivesMap{
-	"cd!":         _cdBang,
-	"cwd":         _cwd,
-	"env":         _env,
-	"environment": _environment,
-	"exec!":       _execBang,
-	"exec!!":      _execBangBang,
-	"exit!":       _exitBang,
-	"shell!":      _shellBang,
+func dirBuiltins(s *session) primitivesMap {
+	return primitivesMap{
+		"cd!": s._cdBang,
+		"cwd": s._cwd,
+	}
+}
+
+func execBuiltins(s *session) primitivesMap {
+	return primitivesMap{
+		"exec!":  s._execBang,
+		"exec!!": s._execBangBang,
+		"shell!": s._shellBang,
+	}
+}
+
+func exitBuiltins(s *session) primitivesMap {
+	return primitivesMap{
+		"exit!": _exitBang,
+	}
+}
+
+func envBuiltins(s *session) primitivesMap {
+	return primitivesMap{
+		"env":         _env,
+		"environment": _environment,
+	}
 }
 
 func toStringSlice(args []Expression) []string {
@@ -46,37 +63,38 @@ func toStringSlice(args []Expression) []string {
 	return params
 }
 
-func _cdBang(args []Expression) (Expression, error) {
+func (s *session) _cdBang(args []Expression) (Expression, error) {
 	if err := typeCheck("(cd! path)", args, ckArity(1), ckString(0)); err != nil {
 		return NIL, err
 	}
 
-	path := args[0].string
-
-	if err := os.Chdir(path); err != nil {
+	dir, err := s.checkPath("cd!", args[0].string)
+	if err != nil {
 		return NIL, err
 	}
 
-	dir, err := os.Getwd()
+	info, err := os.Stat(dir)
 	if err != nil {
 		return NIL, err
 	}
+
+	if !info.IsDir() {
+		return nilExpr("(cd! path) «-- '%v' is not a directory", args[0].string)
+	}
+
+	s.dir = dir
 	return hStr(dir), nil
 }
 
-func _cwd(args []Expression) (Expression, error) {
+func (s *session) _cwd(args []Expression) (Expression, error) {
 	if err := typeCheck("(cwd)", args, ckArity(0)); err != nil {
 		return NIL, err
 	}
 
-	dir, err := os.Getwd()
-	if err != nil {
-		return NIL, err
-	}
-	return hStr(dir), nil
+	return hStr(s.dir), nil
 }
 
-func _shellBang(args []Expression) (Expression, error) {
+func (s *session) _shellBang(args []Expression) (Expression, error) {
 	if err := typeCheck("(shell! cmd args…)", args,
 		ckArityAtLeast(1), ckString(0)); err != nil {
 		return NIL, err
@@ -85,20 +103,30 @@ func _shellBang(args []Expression) (Expression, error) {
 	cmd := args[0].string
 	params := toStringSlice(args[1:])
 
-	proc := exec.Command(cmd, params...)
+	if err := s.checkExecutable("shell!", cmd); err != nil {
+		return NIL, err
+	}
+
+	proc := exec.CommandContext(s.ctx, cmd, params...)
+	proc.Dir = s.dir
 
-	proc.Stdout = os.Stdout
-	proc.Stderr = os.Stderr
+	proc.Stdin = s.stdin
+	proc.Stdout = s.stdout
+	proc.Stderr = s.stderr
 
 	err := proc.Run()
 
+	if err := s.checkContext(); err != nil {
+		return NIL, err
+	}
+
 	if err != nil {
 		return hStr(err.Error()), nil
 	}
 	return NIL, nil
 }
 
-func _execBang(args []Expression) (Expression, error) {
+func (s *session) _execBang(args []Expression) (Expression, error) {
 
 	if err := typeCheck("(exec! cmd args…)", args,
 		ckArityAtLeast(1), ckString(0)); err != nil {
@@ -108,7 +136,19 @@ func _execBang(args []Expression) (Expression, error) {
 	cmd := args[0].string
 	params := toStringSlice(args[1:])
 
-	out, err := exec.Command(cmd, params...).CombinedOutput()
+	if err := s.checkExecutable("exec!", cmd); err != nil {
+		return NIL, err
+	}
+
+	proc := exec.CommandContext(s.ctx, cmd, params...)
+	proc.Dir = s.dir
+
+	out, err := proc.CombinedOutput()
+
+	if err := s.checkContext(); err != nil {
+		return NIL, err
+	}
+
 	if err != nil {
 		return hLst(FALSE, hStr(err.Error()), hStr(string(out))), nil
 	}
@@ -116,7 +156,7 @@ func _execBang(args []Expression) (Expression, error) {
 	return hLst(TRUE, hStr("0"), hStr(string(out))), nil
 }
 
-func _execBangBang(args []Expression) (Expression, error) {
+func (s *session) _execBangBang(args []Expression) (Expression, error) {
 	if err := typeCheck("(exec!! cmd args…)", args,
 		ckArityAtLeast(1), ckString(0)); err != nil {
 		return NIL, err
@@ -125,7 +165,12 @@ func _execBangBang(args []Expression) (Expression, error) {
 	cmd := args[0].string
 	params := toStringSlice(args[1:])
 
-	proc := exec.Command(cmd, params...)
+	if err := s.checkExecutable("exec!!", cmd); err != nil {
+		return NIL, err
+	}
+
+	proc := exec.CommandContext(s.ctx, cmd, params...)
+	proc.Dir = s.dir
 
 	var outBuf bytes.Buffer
 	var errBuf bytes.Buffer
@@ -135,6 +180,10 @@ func _execBangBang(args []Expression) (Expression, error) {
 
 	err := proc.Run()
 
+	if err := s.checkContext(); err != nil {
+		return NIL, err
+	}
+
 	outStr := outBuf.String()
 	errStr := errBuf.String()
 
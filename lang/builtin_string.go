@@ -28,10 +28,12 @@ var stringBuiltins = primitivesMap{
 	"format":       _format,
 	"last-index":   _lastIndex,
 	"lower-case":   _lowerCase,
+	"pr-str":       _prStr,
 	"re-find":      _reFind,
 	"re-list":      _reList,
 	"re-match":     _reMatch,
 	"re-split":     _reSplit,
+	"read-string":  _readString,
 	"replace":      _replace,
 	"starts-with?": _startsWithP,
 	"substr":       _substr,
@@ -292,3 +294,72 @@ func _reList(args []Expression) (Expression, error) {
 
 	return NewExpr(ExpList, es), nil
 }
+
+func _prStr(args []Expression) (Expression, error) {
+	return NewStringExpr(printList(args)), nil
+}
+
+func _readString(args []Expression) (Expression, error) {
+	sig := "(read-string string)"
+	if err := typeCheck(sig, args, ckArity(1), ckString(0)); err != nil {
+		return NilExpression, err
+	}
+
+	if !NewReader(args[0].string).IsBalanced() {
+		return nilExpr("%v «-- incomplete form '%v'", sig, args[0].string)
+	}
+
+	tokens, err := Tokenize(args[0].string)
+	if err != nil {
+		return NilExpression, err
+	}
+	if len(tokens.Tokens) == 0 {
+		return nilExpr("%v «-- no form to read", sig)
+	}
+
+	parser := NewParser()
+	parser.Reset(tokens)
+	form, err := parser.Parse()
+	if err != nil {
+		return NilExpression, err
+	}
+	return readData(form), nil
+}
+
+// dataNames are the names read-string reads as the values they're
+// bound to, so printed data reads back as an equal value.
+var dataNames = map[string]Expression{
+	"nil":   NilExpression,
+	"true":  TrueExpression,
+	"false": FalseExpression,
+}
+
+// readData replaces the dataNames in a form read as data with their
+// values.
+func readData(form Expression) Expression {
+	switch form.tag {
+
+	case ExpSymbol:
+		if value, found := dataNames[form.symbol]; found {
+			return withSpanOf(value, form)
+		}
+
+	case ExpList:
+		return withSpanOf(NewListExpr(readEach(form.list)), form)
+
+	case ExpVector, ExpHashMap, ExpSet:
+		return withSpanOf(fromLiteral(form, readEach(literalElems(form))), form)
+
+	case ExpQuote:
+		return NewExpr(ExpQuote, readData(*form.quote))
+	}
+	return form
+}
+
+func readEach(forms []Expression) []Expression {
+	data := make([]Expression, 0, len(forms))
+	for _, f := range forms {
+		data = append(data, readData(f))
+	}
+	return data
+}
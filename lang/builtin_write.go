@@ -21,38 +21,30 @@ import (
 	"strings"
 )
 
-var writeBuiltins = primitivesMap{
-	"prn": _prn,
-}
-
-func unquoteAll(value string) string {
-	// Surely there's a better or builtin way to do this.
-	reps := map[string]string{
-		"\\n": "\n",
-		"\\r": "\r",
-		"\\t": "\t",
-	}
-	for k, v := range reps {
-		value = strings.Replace(value, k, v, -1)
+func writeBuiltins(s *session) primitivesMap {
+	return primitivesMap{
+		"prn": s._prn,
 	}
-	return value
 }
 
-func _prn(args []Expression) (Expression, error) {
+func (s *session) _prn(args []Expression) (Expression, error) {
 	if len(args) == 0 {
-		fmt.Println("")
+		if _, err := fmt.Fprintln(s.stdout); err != nil {
+			return NilExpression, err
+		}
 		return NilExpression, nil
 	}
 
 	values := make([]string, 0)
-	var value string
 	for _, a := range args {
-		value = a.String()
+		value := a.String()
 		if a.tag == ExpString {
-			value = unquoteAll(a.string)
+			value = a.string
 		}
 		values = append(values, value)
 	}
-	fmt.Println(strings.Join(values, " "))
+	if _, err := fmt.Fprintln(s.stdout, strings.Join(values, " ")); err != nil {
+		return NilExpression, err
+	}
 	return NilExpression, nil
 }
@@ -0,0 +1,1002 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The VM interpreter compiles each form, once its macros are expanded,
+// to a proc: instructions for a stack machine, with the constants,
+// call sites and nested functions they refer to kept in pools beside
+// the code. A local is resolved when it's compiled to an address: how
+// many enclosing functions out its frame is, and its slot in that
+// frame. Frames are the environment's own, so the VM's closures and
+// the other interpreters' functions can see each other's locals. Any
+// other name is looked up in the environment when it's
+// evaluated, so the VM sees the same globals, namespaces and builtins
+// as the other interpreters.
+//
+// Mistakes in special forms compile to an instruction returning the
+// error, so they're reported when the form is evaluated, as they are
+// by the other interpreters.
+
+//-----------------------------------------------------------------------------
+// Instructions
+//-----------------------------------------------------------------------------
+
+// instr is an opcode in the low 8 bits and its operand in the rest.
+type instr uint32
+
+type opcode uint8
+
+const (
+	opConst       opcode = iota // push consts[a]
+	opLocal                     // push the local at address a
+	opSetLocal                  // store the top of the stack in the local at a
+	opGlobal                    // push the value of the name consts[a]
+	opSetGlobal                 // rebind the name consts[a] to the top of the stack
+	opDefine                    // bind the name consts[a] to the top of the stack
+	opPop                       // drop the top of the stack
+	opJump                      // continue at a
+	opJumpIf                    // pop, continuing at a if truthy
+	opJumpUnless                // pop, continuing at a if not truthy
+	opAnd                       // continue at a if the top isn't truthy, else pop
+	opOr                        // continue at a if the top is truthy, else pop
+	opSupplied                  // push whether the call has more than a args
+	opMatch                     // push whether case key consts[a] matches the top
+	opMacro                     // if the top is a macro, expand and evaluate sites[a]
+	opCall                      // call with the args of sites[a]
+	opTailCall                  // call with the args of sites[a], replacing this call
+	opReturn                    // return the top of the stack
+	opClosure                   // push a function made from lambdas[a]
+	opBind                      // pop a value, destructuring it with patterns[a]
+	opCollection                // replace the values of literals[a] with the collection
+	opTemplate                  // replace the parts of templates[a] with the template
+	opQuote                     // quote the top of the stack
+	opQualify                   // push the symbol consts[a] qualified for the namespace
+	opTry                       // evaluate tries[a]
+	opLoop                      // pop a list and a function, applying it to each element
+	opSwap                      // pop a args to swap!
+	opMacroexpand               // replace the form on top with its expansion
+	opSpecial                   // evaluate the special form consts[a]
+	opFail                      // return errs[a]
+)
+
+const maxOperand = 1<<24 - 1
+
+func mkInstr(op opcode, a int) instr {
+	return instr(op) | instr(a)<<8
+}
+
+func (in instr) op() opcode {
+	return opcode(in & 0xff)
+}
+
+func (in instr) arg() int {
+	return int(in >> 8)
+}
+
+// Addresses pack a local's depth above its slot.
+const (
+	maxDepth = 1<<8 - 1
+	maxSlot  = 1<<16 - 1
+)
+
+func address(depth, slot int) int {
+	return depth<<16 | slot
+}
+
+//-----------------------------------------------------------------------------
+// Procs
+//-----------------------------------------------------------------------------
+
+// proc is the compiled code of a top level form, a function body, or
+// a block run in the frame of the code around it.
+type proc struct {
+	name      string
+	code      []instr
+	spans     []*Span // per instruction, the innermost form with a position
+	consts    []Expression
+	sites     []*callSite
+	lambdas   []*lambdaCode
+	patterns  []patternCode
+	literals  []literalCode
+	templates []templateCode
+	tries     []tryCode
+	errs      []error
+	params    *paramCode // how a function binds its args
+	frame     *frameLayout
+}
+
+// frameLayout is the names of the slots in the frames a proc runs in.
+// Blocks compiled later, such as macro expansions, may add slots to the
+// frames, but not to the layout.
+type frameLayout struct {
+	names []string
+}
+
+func (l *frameLayout) size() int {
+	return len(l.names)
+}
+
+// callSite is a call's number of args, and what's needed to compile
+// and evaluate its expansion instead if the operator is a macro.
+type callSite struct {
+	argc  int
+	form  Expression
+	scope *scope
+	end   int // where to continue after the call
+}
+
+// lambdaCode is a function without its environment or frame, and its
+// compiled body.
+type lambdaCode struct {
+	template Expression
+	proc     *proc
+}
+
+// patternCode is a destructuring pattern and the slots of the names
+// it binds.
+type patternCode struct {
+	pattern Expression
+	names   []string
+	slots   []int
+}
+
+type literalCode struct {
+	form Expression
+	size int // the number of values
+}
+
+// templateCode is a list, vector, set or hash-map in a quasiquote
+// template, and which of its parts are spliced.
+type templateCode struct {
+	form   Expression
+	splice []bool
+}
+
+type tryCode struct {
+	body    *proc
+	catch   int // the slot of the error, if there's a handler
+	handler *proc
+	cleanup *proc
+}
+
+// paramCode is where a function's args go in its frame. Defaults for
+// missing optional params are computed by the start of its code.
+type paramCode struct {
+	required []paramSlot
+	optional []int
+	rest     int // -1 if there's no rest param
+}
+
+type paramSlot struct {
+	slot    int
+	pattern int // -1 if it's a name
+}
+
+// spanAt returns the position of the form instruction i came from.
+func (p *proc) spanAt(i int) *Span {
+	if i < 0 || i >= len(p.spans) {
+		return nil
+	}
+	return p.spans[i]
+}
+
+//-----------------------------------------------------------------------------
+// Scopes
+//-----------------------------------------------------------------------------
+
+// scope is the locals visible where a form is compiled. Scopes are
+// never changed, so one can be kept to compile more code later.
+type scope struct {
+	parent *scope   // the enclosing function's scope
+	names  *binding // innermost first
+	frame  *frameLayout
+}
+
+type binding struct {
+	name string
+	slot int
+	next *binding
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent, frame: &frameLayout{}}
+}
+
+// declare returns a scope in which name is bound to a new slot.
+func (s *scope) declare(name string) (*scope, int) {
+	slot := s.frame.size()
+	s.frame.names = append(s.frame.names, name)
+	return &scope{parent: s.parent, names: &binding{name, slot, s.names}, frame: s.frame}, slot
+}
+
+// resolve returns the depth and slot of the local name, or false if
+// it's not a local.
+func (s *scope) resolve(name string) (int, int, bool) {
+	for depth := 0; s != nil; depth, s = depth+1, s.parent {
+		for b := s.names; b != nil; b = b.next {
+			if b.name == name {
+				return depth, b.slot, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+func (s *scope) isLocal(name string) bool {
+	_, _, found := s.resolve(name)
+	return found
+}
+
+//-----------------------------------------------------------------------------
+// Compiler
+//-----------------------------------------------------------------------------
+
+type compiler struct {
+	p    *proc
+	span *Span
+}
+
+func newCompiler(name string, frame *frameLayout) *compiler {
+	return &compiler{p: &proc{name: name, frame: frame}}
+}
+
+// compileForm compiles a top level form, in a frame of its own.
+func compileForm(form Expression) *proc {
+	s := newScope(nil)
+	c := newCompiler("", s.frame)
+	c.expr(s, form, true)
+	c.emit(opReturn, 0)
+	return c.p
+}
+
+// compileFunction compiles the body of fn, binding its params in a
+// frame whose parent is that of scope s.
+func compileFunction(s *scope, fn Expression) *proc {
+	fs := newScope(s)
+	c := newCompiler(fn.functionName, fs.frame)
+	c.span = fn.functionBody.span
+
+	sig := fn.signature
+	params := &paramCode{rest: -1}
+
+	for _, p := range sig.required {
+		if p.IsSymbol() {
+			var slot int
+			fs, slot = fs.declare(p.symbol)
+			params.required = append(params.required, paramSlot{slot: slot, pattern: -1})
+			continue
+		}
+		var pattern int
+		fs, pattern = c.pattern(fs, p)
+		params.required = append(params.required, paramSlot{pattern: pattern})
+	}
+
+	// Each default sees the params before it.
+	for i, opt := range sig.optional {
+		before := fs
+		var slot int
+		fs, slot = fs.declare(opt.name.symbol)
+		params.optional = append(params.optional, slot)
+
+		if opt.fallback.tag != ExpNil {
+			c.emit(opSupplied, len(sig.required)+i)
+			skip := c.emit(opJumpIf, 0)
+			c.expr(before, opt.fallback, false)
+			c.local(opSetLocal, 0, slot)
+			c.emit(opPop, 0)
+			c.patch(skip)
+		}
+	}
+
+	if sig.rest != nil {
+		fs, params.rest = fs.declare(sig.rest.symbol)
+	}
+
+	c.p.params = params
+	c.expr(fs, *fn.functionBody, true)
+	c.emit(opReturn, 0)
+	return c.p
+}
+
+// compileBlock compiles a form to run in the frame of the code it's
+// part of.
+func compileBlock(s *scope, name string, form Expression, at *Span) *proc {
+	c := newCompiler(name, s.frame)
+	c.span = at
+	c.expr(s, form, true)
+	c.emit(opReturn, 0)
+	return c.p
+}
+
+// noInstr is where emit says an instruction it couldn't emit is.
+const noInstr = -1
+
+// emit appends an instruction, returning where it is. An operand too
+// large to encode, such as the index of a constant in a huge form, is
+// a compile error, and emit returns noInstr.
+func (c *compiler) emit(op opcode, a int) int {
+	if a < 0 || a > maxOperand {
+		c.failf("form is too large to compile")
+		return noInstr
+	}
+	c.p.code = append(c.p.code, mkInstr(op, a))
+	c.p.spans = append(c.p.spans, c.span)
+	return len(c.p.code) - 1
+}
+
+// patch points the jump at i to the next instruction, or makes it fail
+// if the code is too long to jump to it. A jump emit couldn't emit has
+// already failed, so there's nothing to patch.
+func (c *compiler) patch(i int) {
+	if i == noInstr {
+		return
+	}
+	if len(c.p.code) > maxOperand {
+		c.failf("form is too large to compile")
+		c.p.code[i] = c.p.code[len(c.p.code)-1]
+		return
+	}
+	c.p.code[i] = mkInstr(c.p.code[i].op(), len(c.p.code))
+}
+
+func (c *compiler) constant(e Expression) int {
+	c.p.consts = append(c.p.consts, e)
+	return len(c.p.consts) - 1
+}
+
+// fail emits code returning err. Once the pool of errors is full, it
+// returns the last error in it instead.
+func (c *compiler) fail(err error) {
+	if len(c.p.errs) <= maxOperand {
+		c.p.errs = append(c.p.errs, err)
+	}
+	c.emit(opFail, len(c.p.errs)-1)
+}
+
+func (c *compiler) failf(format string, params ...interface{}) {
+	c.fail(fmt.Errorf(format, params...))
+}
+
+// local emits an instruction for the local at depth and slot.
+func (c *compiler) local(op opcode, depth, slot int) {
+	if depth > maxDepth || slot > maxSlot {
+		c.failf("too many nested functions or locals to compile")
+		return
+	}
+	c.emit(op, address(depth, slot))
+}
+
+// pattern declares the names bound by p, returning the scope they're
+// bound in and the index of the code binding them.
+func (c *compiler) pattern(s *scope, p Expression) (*scope, int) {
+	code := patternCode{pattern: p, names: patternNames(p)}
+	for _, name := range code.names {
+		var slot int
+		s, slot = s.declare(name)
+		code.slots = append(code.slots, slot)
+	}
+	c.p.patterns = append(c.p.patterns, code)
+	return s, len(c.p.patterns) - 1
+}
+
+// lambda compiles fn, returning its index in the lambda pool.
+func (c *compiler) lambda(s *scope, fn Expression) int {
+	c.p.lambdas = append(c.p.lambdas, &lambdaCode{template: fn, proc: compileFunction(s, fn)})
+	return len(c.p.lambdas) - 1
+}
+
+// functionTemplate returns a function to be completed with an
+// environment and frame when it's made.
+func functionTemplate(tag ExpressionType, name string, params, body Expression) Expression {
+	return Expression{
+		tag:            tag,
+		functionName:   name,
+		functionParams: &params,
+		signature:      newSignature(params),
+		functionBody:   &body,
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Forms
+//-----------------------------------------------------------------------------
+
+// expr compiles e, leaving its value on the stack. In tail position,
+// a call replaces the call whose code it's part of.
+func (c *compiler) expr(s *scope, e Expression, tail bool) {
+	if e.span != nil {
+		defer func(at *Span) { c.span = at }(c.span)
+		c.span = e.span
+	}
+
+	switch e.tag {
+
+	case ExpSymbol:
+		if depth, slot, found := s.resolve(e.symbol); found {
+			c.local(opLocal, depth, slot)
+			return
+		}
+		c.emit(opGlobal, c.constant(e))
+
+	case ExpQuote:
+		c.emit(opConst, c.constant(*e.quote))
+
+	case ExpHashMap, ExpVector, ExpSet:
+		c.collection(s, e)
+
+	case ExpList:
+		c.list(s, e, tail)
+
+	default:
+		c.emit(opConst, c.constant(e))
+	}
+}
+
+func (c *compiler) list(s *scope, e Expression, tail bool) {
+	rest := e.Tail()
+
+	switch e.Head().symbol {
+
+	case "loop":
+		c.loop(s, rest, 0, "(loop (fn (x) ...) lst)")
+
+	case "loop-index":
+		c.loop(s, rest, 1, "(loop-index (fn (i x) ...) lst)")
+
+	case "if":
+		c.ifForm(s, rest, tail)
+
+	case "cond":
+		c.cond(s, rest, tail)
+
+	case "case":
+		c.caseForm(s, rest, tail)
+
+	case "when", "unless":
+		c.when(s, rest, e.Head().symbol == "when", tail)
+
+	case "and":
+		c.logic(s, rest, opAnd, tail)
+
+	case "or":
+		c.logic(s, rest, opOr, tail)
+
+	case "do":
+		c.do(s, rest.list, tail)
+
+	case "let":
+		if rest.Head().IsSymbol() {
+			c.namedLet(s, e, rest, tail)
+		} else {
+			c.let(s, rest.Head(), rest.Tail(), tail)
+		}
+
+	case "def":
+		c.def(s, rest.Head(), rest.Tail())
+
+	case "defmacro", "ns", "require", "load":
+		c.emit(opSpecial, c.constant(e))
+
+	case "quasiquote":
+		if err := typeCheck("(quasiquote form)", rest.list, ckArity(1)); err != nil {
+			c.fail(err)
+			return
+		}
+		c.quasiquote(s, rest.list[0])
+
+	case "set!":
+		c.set(s, rest)
+
+	case "swap!":
+		c.swap(s, rest)
+
+	case "try":
+		c.try(s, rest)
+
+	case "macroexpand":
+		c.macroexpand(s, rest, false)
+
+	case "macroexpand-1":
+		c.macroexpand(s, rest, true)
+
+	case "defun":
+		c.defun(s, rest.Head(), rest.Tail().Head(), rest.Tail().Tail())
+
+	case "fn", "lambda":
+		params := rest.Head()
+		if !params.IsList() {
+			c.failf("in (fn (params) (body)) ← params must be a list")
+			return
+		}
+		c.closure(s, functionTemplate(ExpLambda, GenSym("fn").symbol, params, rest.Tail().Head()))
+
+	default:
+		c.call(s, e, tail)
+	}
+}
+
+func (c *compiler) collection(s *scope, e Expression) {
+	if isConstant(e) {
+		c.emit(opConst, c.constant(e))
+		return
+	}
+
+	elems := literalElems(e)
+	for _, elem := range elems {
+		c.expr(s, elem, false)
+	}
+	c.p.literals = append(c.p.literals, literalCode{form: e, size: len(elems)})
+	c.emit(opCollection, len(c.p.literals)-1)
+}
+
+func (c *compiler) call(s *scope, e Expression, tail bool) {
+	op := NilExpression
+	args := []Expression{}
+	if len(e.list) > 0 {
+		op, args = e.list[0], e.list[1:]
+	}
+
+	c.expr(s, op, false)
+
+	site := &callSite{argc: len(args), form: e, scope: s}
+	c.p.sites = append(c.p.sites, site)
+	index := len(c.p.sites) - 1
+
+	// A global might be a macro defined after the form was expanded.
+	if op.IsSymbol() && !s.isLocal(op.symbol) {
+		c.emit(opMacro, index)
+	}
+
+	for _, arg := range args {
+		c.expr(s, arg, false)
+	}
+	c.invoke(index, tail)
+}
+
+func (c *compiler) invoke(site int, tail bool) {
+	if tail {
+		c.emit(opTailCall, site)
+	} else {
+		c.emit(opCall, site)
+	}
+	c.p.sites[site].end = len(c.p.code)
+}
+
+func (c *compiler) closure(s *scope, fn Expression) {
+	if fn.signature.err != nil {
+		c.fail(fn.signature.err)
+		return
+	}
+	c.emit(opClosure, c.lambda(s, fn))
+}
+
+func (c *compiler) do(s *scope, forms []Expression, tail bool) {
+	if len(forms) == 0 {
+		c.emit(opConst, c.constant(NilExpression))
+		return
+	}
+
+	for _, e := range forms[:len(forms)-1] {
+		c.expr(s, e, false)
+		c.emit(opPop, 0)
+	}
+	c.expr(s, forms[len(forms)-1], tail)
+}
+
+//-----------------------------------------------------------------------------
+// Conditionals
+//-----------------------------------------------------------------------------
+
+func (c *compiler) ifForm(s *scope, args Expression, tail bool) {
+	argc := len(args.list)
+	if argc < 2 {
+		c.failf("too few arguments (%v) to if", argc)
+		return
+	}
+	if argc > 3 {
+		c.failf("too many arguments (%v) to if", argc)
+		return
+	}
+
+	c.expr(s, args.list[0], false)
+	other := c.emit(opJumpUnless, 0)
+	c.expr(s, args.list[1], tail)
+	end := c.emit(opJump, 0)
+	c.patch(other)
+	if argc == 3 {
+		c.expr(s, args.list[2], tail)
+	} else {
+		c.emit(opConst, c.constant(NilExpression))
+	}
+	c.patch(end)
+}
+
+func (c *compiler) cond(s *scope, args Expression, tail bool) {
+	if len(args.list)%2 != 0 {
+		c.failf("(cond test expr ...) «-- expects test/expr pairs")
+		return
+	}
+
+	ends := make([]int, 0)
+	fallback := true
+
+	for i := 0; i < len(args.list); i += 2 {
+		test := args.list[i]
+		if test.IsSymbol() && test.symbol == elseClause {
+			c.expr(s, args.list[i+1], tail)
+			fallback = false
+			break
+		}
+
+		c.expr(s, test, false)
+		next := c.emit(opJumpUnless, 0)
+		c.expr(s, args.list[i+1], tail)
+		ends = append(ends, c.emit(opJump, 0))
+		c.patch(next)
+	}
+
+	if fallback {
+		c.emit(opConst, c.constant(NilExpression))
+	}
+	for _, end := range ends {
+		c.patch(end)
+	}
+}
+
+func (c *compiler) caseForm(s *scope, args Expression, tail bool) {
+	if len(args.list) < 1 {
+		c.failf("(case expr key expr ... default?) «-- expects an expression to match")
+		return
+	}
+
+	c.expr(s, args.list[0], false)
+
+	ends := make([]int, 0)
+	clauses := args.list[1:]
+	for i := 0; i+1 < len(clauses); i += 2 {
+		c.emit(opMatch, c.constant(clauses[i]))
+		next := c.emit(opJumpUnless, 0)
+		c.emit(opPop, 0)
+		c.expr(s, clauses[i+1], tail)
+		ends = append(ends, c.emit(opJump, 0))
+		c.patch(next)
+	}
+
+	c.emit(opPop, 0)
+	if len(clauses)%2 != 0 {
+		c.expr(s, clauses[len(clauses)-1], tail)
+	} else {
+		c.emit(opConst, c.constant(NilExpression))
+	}
+	for _, end := range ends {
+		c.patch(end)
+	}
+}
+
+func (c *compiler) when(s *scope, args Expression, want bool, tail bool) {
+	if len(args.list) < 1 {
+		if want {
+			c.failf("(when test body…) «-- expects a test")
+		} else {
+			c.failf("(unless test body…) «-- expects a test")
+		}
+		return
+	}
+
+	c.expr(s, args.list[0], false)
+	skip := opJumpUnless
+	if !want {
+		skip = opJumpIf
+	}
+	other := c.emit(skip, 0)
+	c.do(s, args.list[1:], tail)
+	end := c.emit(opJump, 0)
+	c.patch(other)
+	c.emit(opConst, c.constant(NilExpression))
+	c.patch(end)
+}
+
+// logic compiles and or or, which stop at the first value that isn't
+// or is truthy.
+func (c *compiler) logic(s *scope, args Expression, op opcode, tail bool) {
+	if len(args.list) == 0 {
+		c.emit(opConst, c.constant(NilExpression))
+		return
+	}
+
+	ends := make([]int, 0)
+	last := len(args.list) - 1
+	for _, e := range args.list[:last] {
+		c.expr(s, e, false)
+		ends = append(ends, c.emit(op, 0))
+	}
+	c.expr(s, args.list[last], tail)
+	for _, end := range ends {
+		c.patch(end)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Bindings
+//-----------------------------------------------------------------------------
+
+// let binds each name before any value's computed, so a function can
+// refer to itself or to the other names. Values are computed in order.
+func (c *compiler) let(s *scope, clauses, body Expression, tail bool) {
+	if !clauses.IsList() {
+		c.failf("let bindings should be a list (let (a 1 b 2) ...)")
+		return
+	}
+
+	if clauses.Size()%2 != 0 {
+		c.failf("let bindings must contain an even number of left/right pairs")
+		return
+	}
+
+	slots := make(map[int]int)
+	for i := 0; i < clauses.Size(); i += 2 {
+		if name := clauses.list[i]; name.IsSymbol() {
+			s, slots[i] = s.declare(name.symbol)
+		}
+	}
+
+	for i := 0; i < clauses.Size(); i += 2 {
+		name := clauses.list[i]
+		c.expr(s, clauses.list[i+1], false)
+
+		if name.IsSymbol() {
+			c.local(opSetLocal, 0, slots[i])
+			c.emit(opPop, 0)
+			continue
+		}
+
+		if err := checkPattern(name); err != nil {
+			c.fail(err)
+			return
+		}
+
+		var pattern int
+		s, pattern = c.pattern(s, name)
+		at := c.span
+		if name.span != nil {
+			c.span = name.span
+		}
+		c.emit(opBind, pattern)
+		c.span = at
+	}
+
+	c.do(s, body.list, tail)
+}
+
+// namedLet binds name to a function of the let's names, in a scope
+// the values can't see, and calls it.
+func (c *compiler) namedLet(s *scope, e, args Expression, tail bool) {
+	if len(args.list) < 2 || !args.list[1].IsList() {
+		c.failf("named let bindings should be a list (let name (a 1 b 2) ...)")
+		return
+	}
+
+	name, clauses := args.list[0], args.list[1]
+
+	if err := checkLetName(name); err != nil {
+		c.fail(err)
+		return
+	}
+
+	if clauses.Size()%2 != 0 {
+		c.failf("let bindings must contain an even number of left/right pairs")
+		return
+	}
+
+	params := make([]Expression, 0)
+	values := make([]Expression, 0)
+	for i := 0; i < clauses.Size(); i += 2 {
+		params = append(params, clauses.list[i])
+		values = append(values, clauses.list[i+1])
+	}
+
+	fn := functionTemplate(ExpLambda, name.symbol, NewListExpr(params), WrapImplicitDo(args.list[2:]))
+	if fn.signature.err != nil {
+		c.fail(fn.signature.err)
+		return
+	}
+
+	loop, slot := s.declare(name.symbol)
+	c.emit(opClosure, c.lambda(loop, fn))
+	c.local(opSetLocal, 0, slot)
+
+	for _, value := range values {
+		c.expr(s, value, false)
+	}
+
+	c.p.sites = append(c.p.sites, &callSite{argc: len(values), form: e, scope: s})
+	c.invoke(len(c.p.sites)-1, tail)
+}
+
+func (c *compiler) def(s *scope, name, body Expression) {
+	if !name.IsSymbol() {
+		c.failf("(def name expr) «- name must be a symbol, not '%v'", ExprTypeName(name.tag))
+		return
+	}
+
+	switch argc := len(body.list); {
+	case argc == 0:
+		c.emit(opConst, c.constant(NilExpression))
+	case argc != 1:
+		c.failf("(def name expr) «-- takes 1 expr, you offered %v", argc)
+		return
+	default:
+		c.expr(s, body.list[0], false)
+	}
+	c.emit(opDefine, c.constant(name))
+}
+
+func (c *compiler) defun(s *scope, name, params, body Expression) {
+	sig := "(defun name (params) body…)"
+
+	if !name.IsSymbol() {
+		c.failf("%v «-- name must be a symbol", sig)
+		return
+	}
+
+	if !params.IsList() {
+		c.failf("%v «-- params must be a list", sig)
+		return
+	}
+
+	c.closure(s, functionTemplate(ExpFunction, name.symbol, params, WrapImplicitDo(body.list)))
+	c.emit(opDefine, c.constant(name))
+}
+
+func (c *compiler) set(s *scope, args Expression) {
+	if err := typeCheck(setSig, args.list, ckArity(2), ckType(0, ExpSymbol)); err != nil {
+		c.fail(err)
+		return
+	}
+
+	c.expr(s, args.list[1], false)
+
+	name := args.list[0]
+	if depth, slot, found := s.resolve(name.symbol); found {
+		c.local(opSetLocal, depth, slot)
+		return
+	}
+	c.emit(opSetGlobal, c.constant(name))
+}
+
+//-----------------------------------------------------------------------------
+// Other special forms
+//-----------------------------------------------------------------------------
+
+func (c *compiler) loop(s *scope, args Expression, index int, sig string) {
+	if err := typeCheck(sig, args.list, ckArity(2), ckFuncable(0)); err != nil {
+		c.fail(err)
+		return
+	}
+
+	c.expr(s, args.list[0], false)
+	c.expr(s, args.list[1], false)
+	c.emit(opLoop, index)
+}
+
+func (c *compiler) swap(s *scope, args Expression) {
+	if err := typeCheck(swapSig, args.list, ckArityAtLeast(2)); err != nil {
+		c.fail(err)
+		return
+	}
+
+	for _, arg := range args.list {
+		c.expr(s, arg, false)
+	}
+	c.emit(opSwap, len(args.list))
+}
+
+func (c *compiler) try(s *scope, args Expression) {
+	t, err := parseTry(args)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+
+	code := tryCode{catch: -1}
+	code.body = compileBlock(s, c.p.name, t.body, c.span)
+
+	if t.catch != nil {
+		var handler *scope
+		handler, code.catch = s.declare(t.catch.symbol)
+		code.handler = compileBlock(handler, c.p.name, t.handler, c.span)
+	}
+
+	if t.cleanup != nil {
+		code.cleanup = compileBlock(s, c.p.name, *t.cleanup, c.span)
+	}
+
+	c.p.tries = append(c.p.tries, code)
+	c.emit(opTry, len(c.p.tries)-1)
+}
+
+func (c *compiler) macroexpand(s *scope, args Expression, once bool) {
+	sig := "(macroexpand form)"
+	if once {
+		sig = "(macroexpand-1 form)"
+	}
+
+	if err := typeCheck(sig, args.list, ckArity(1)); err != nil {
+		c.fail(err)
+		return
+	}
+
+	c.expr(s, args.list[0], false)
+	if once {
+		c.emit(opMacroexpand, 1)
+	} else {
+		c.emit(opMacroexpand, 0)
+	}
+}
+
+// quasiquote compiles a template to code building it, with unquoted
+// forms evaluated where they are.
+func (c *compiler) quasiquote(s *scope, template Expression) {
+	switch template.tag {
+
+	case ExpQuote:
+		c.quasiquote(s, *template.quote)
+		c.emit(opQuote, 0)
+
+	case ExpList, ExpVector, ExpHashMap, ExpSet:
+		if isUnquote(template) {
+			if template.list[0].symbol == "unquote-splicing" {
+				c.fail(errors.New("~@ must be used inside a list, vector or set"))
+				return
+			}
+			c.expr(s, template.list[1], false)
+			return
+		}
+
+		code := templateCode{form: template}
+		for _, e := range templateElems(template) {
+			spliced := isSplice(e)
+			if spliced && template.tag == ExpHashMap {
+				c.fail(errors.New("~@ can't be used inside a hash-map"))
+				return
+			}
+			if spliced {
+				c.expr(s, e.list[1], false)
+			} else {
+				c.quasiquote(s, e)
+			}
+			code.splice = append(code.splice, spliced)
+		}
+		c.p.templates = append(c.p.templates, code)
+		c.emit(opTemplate, len(c.p.templates)-1)
+
+	case ExpSymbol:
+		if s.isLocal(template.symbol) {
+			c.emit(opConst, c.constant(template))
+			return
+		}
+		c.emit(opQualify, c.constant(template))
+
+	default:
+		c.emit(opConst, c.constant(template))
+	}
+}
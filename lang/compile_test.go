@@ -0,0 +1,42 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"strings"
+	"testing"
+)
+
+// A form with more constants or code than an operand can address takes
+// gigabytes to build, so this emits an operand that's too large
+// instead.
+func TestOperandTooLarge(t *testing.T) {
+	c := newCompiler("", &frameLayout{})
+	jump := c.emit(opJumpIf, maxOperand+1)
+	if jump != noInstr {
+		t.Errorf("Expected noInstr for an operand too large, got %v.", jump)
+	}
+	c.emit(opConst, c.constant(NewIntExpr(1)))
+	c.patch(jump)
+	c.emit(opReturn, 0)
+
+	vm := NewInterpreter(VM).(VMInterpreter)
+	_, err := vm.run(vm.environment, c.p)
+	if err == nil || !strings.Contains(err.Error(), "form is too large to compile") {
+		t.Errorf("Expected a compile error, got '%v'.", err)
+	}
+}
@@ -0,0 +1,199 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Each of these returns the form to evaluate next rather than its
+// value, so the TCO interpreter can evaluate it in tail position.
+
+//-----------------------------------------------------------------------------
+// COND
+//-----------------------------------------------------------------------------
+
+const elseClause = "else"
+
+func evalCond(interp Interpreter, env *Environment, args Expression) (Expression, error) {
+	if len(args.list)%2 != 0 {
+		return nilExpr("(cond test expr ...) «-- expects test/expr pairs")
+	}
+
+	for i := 0; i < len(args.list); i += 2 {
+		test := args.list[i]
+		if test.IsSymbol() && test.symbol == elseClause {
+			return args.list[i+1], nil
+		}
+
+		result, err := interp.Evaluate(env, test)
+		if err != nil {
+			return NilExpression, err
+		}
+
+		if result.IsTruthy() {
+			return args.list[i+1], nil
+		}
+	}
+
+	return NilExpression, nil
+}
+
+//-----------------------------------------------------------------------------
+// CASE
+//-----------------------------------------------------------------------------
+
+func evalCase(interp Interpreter, env *Environment, args Expression) (Expression, error) {
+	if len(args.list) < 1 {
+		return nilExpr("(case expr key expr ... default?) «-- expects an expression to match")
+	}
+
+	value, err := interp.Evaluate(env, args.list[0])
+	if err != nil {
+		return NilExpression, err
+	}
+
+	clauses := args.list[1:]
+	for i := 0; i+1 < len(clauses); i += 2 {
+		if caseMatches(clauses[i], value) {
+			return clauses[i+1], nil
+		}
+	}
+
+	// An odd clause out is the default.
+	if len(clauses)%2 != 0 {
+		return clauses[len(clauses)-1], nil
+	}
+	return NilExpression, nil
+}
+
+// caseMatches returns true if the unevaluated key, or for a list any
+// of its elements, equals value.
+func caseMatches(key, value Expression) bool {
+	if key.IsList() {
+		for _, k := range key.list {
+			if k.Equals(value) {
+				return true
+			}
+		}
+		return false
+	}
+	return key.Equals(value)
+}
+
+//-----------------------------------------------------------------------------
+// WHEN / UNLESS
+//-----------------------------------------------------------------------------
+
+func evalWhen(interp Interpreter, env *Environment, args Expression, want bool) (Expression, error) {
+	if len(args.list) < 1 {
+		if want {
+			return nilExpr("(when test body…) «-- expects a test")
+		}
+		return nilExpr("(unless test body…) «-- expects a test")
+	}
+
+	result, err := interp.Evaluate(env, args.list[0])
+	if err != nil {
+		return NilExpression, err
+	}
+
+	if result.IsTruthy() != want {
+		return NilExpression, nil
+	}
+	return WrapImplicitDo(args.list[1:]), nil
+}
+
+//-----------------------------------------------------------------------------
+// NAMED LET
+//-----------------------------------------------------------------------------
+
+// specialForms are the names the interpreters evaluate as special
+// forms rather than as calls.
+var specialForms = map[string]bool{
+	"loop": true, "loop-index": true, "if": true, "cond": true,
+	"case": true, "when": true, "unless": true, "and": true, "or": true,
+	"do": true, "let": true, "def": true, "defmacro": true,
+	"quasiquote": true, "set!": true, "swap!": true, "try": true,
+	"ns": true, "require": true, "load": true, "macroexpand": true,
+	"macroexpand-1": true, "defun": true, "fn": true, "lambda": true,
+}
+
+// checkLetName returns an error if name is a special form, as calling
+// it in the let's body would evaluate the special form instead.
+func checkLetName(name Expression) error {
+	if specialForms[name.symbol] {
+		return fmt.Errorf("named let can't be called '%v', which is a special form", name.symbol)
+	}
+	return nil
+}
+
+// evalNamedLet binds name to a function of the let's names and calls
+// it with their initial values, so calling name again in tail position
+// loops without growing the stack:
+//
+//	(let next (i 0 total 0)
+//	  (if (< i 10)
+//	    (next (inc i) (+ total i))
+//	    total))
+//
+// It returns the environment and body of the first call.
+func evalNamedLet(interp Interpreter, env *Environment, args Expression) (*Environment, Expression, error) {
+	if len(args.list) < 2 || !args.list[1].IsList() {
+		return env, NilExpression,
+			errors.New("named let bindings should be a list (let name (a 1 b 2) ...)")
+	}
+
+	name, clauses := args.list[0], args.list[1]
+
+	if err := checkLetName(name); err != nil {
+		return env, NilExpression, err
+	}
+
+	if clauses.Size()%2 != 0 {
+		return env, NilExpression,
+			errors.New("let bindings must contain an even number of left/right pairs")
+	}
+
+	params := make([]Expression, 0)
+	argv := make([]Expression, 0)
+
+	for i := 0; i < clauses.Size(); i += 2 {
+		value, err := interp.Evaluate(env, clauses.list[i+1])
+		if err != nil {
+			return env, NilExpression, err
+		}
+		params = append(params, clauses.list[i])
+		argv = append(argv, value)
+	}
+
+	loopEnv, frame := env.extend()
+	frame.bind(name.symbol, NilExpression)
+
+	fn := NewLambdaExpr(loopEnv, name, NewListExpr(params), WrapImplicitDo(args.list[2:]))
+	if fn.signature.err != nil {
+		return env, NilExpression, fn.signature.err
+	}
+	fn.functionEnv.Replace(name, fn)
+
+	bodyEnv, err := fn.functionEnv.bindArgs(fn, argv, interp.Evaluate)
+	if err != nil {
+		return env, NilExpression, err
+	}
+	return bodyEnv, *fn.functionBody, nil
+}
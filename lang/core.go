@@ -27,9 +27,10 @@ func spacify(s string) string {
 // Core functions
 var Core = spacify(`
 (defun map (f xs)
-	(if (= xs '())
-		'()
-		(prepend (f (head xs)) (map f (tail xs)))))
+	(let next (accum '() xs xs)
+		(if (= xs '())
+			accum
+			(next (append accum (f (head xs))) (tail xs)))))
 
 (defun reduce (f a xs)
 	(if (= xs '())
@@ -37,13 +38,10 @@ var Core = spacify(`
 		(reduce f (f a (head xs)) (tail xs))))
 
 (defun filter (f xs)
-	(if (= xs '())
-		xs
-		(let (x (head xs)
-					y (tail xs))
-			(if (f (head xs))
-				(prepend (head xs) (filter f (tail xs)))
-				(filter f (tail xs))))))
+	(let next (accum '() xs xs)
+		(if (= xs '())
+			accum
+			(next (if (f (head xs)) (append accum (head xs)) accum) (tail xs)))))
 
 (defun dec (x)
 	(- x 1))
@@ -52,11 +50,10 @@ var Core = spacify(`
 	(+ x 1))
 
 (defun range (x)
-	(let (_range (fn (x)
-								 (if (= x 0)
-									 (list 0)
-									 (append (_range (- x 1)) x))))
-		(_range (- x 1))))
+	(let next (accum '() i 0)
+		(if (< i x)
+			(next (append accum i) (+ i 1))
+			accum)))
 
 (defun take (x lst)
 	(let (_take (fn (accum ls)
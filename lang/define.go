@@ -0,0 +1,152 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Variadic means a builtin accepts any number of arguments beyond its
+// minimum.
+const Variadic = -1
+
+// Builtin describes a Go function an embedding program exposes to
+// haki scripts.
+type Builtin struct {
+	Name    string        // symbol the function is bound to
+	Doc     string        // description returned by (doc fn)
+	MinArgs int           // fewest arguments accepted
+	MaxArgs int           // most arguments accepted, or Variadic
+	Fn      PrimitiveFunc // the implementation
+}
+
+var metaBuiltins = primitivesMap{
+	"doc":    _doc,
+	"gensym": _gensym,
+}
+
+// NewPrimitiveExpr returns an expression wrapping a named Go function.
+func NewPrimitiveExpr(name, doc string, fn PrimitiveFunc) Expression {
+	e := NewExpr(ExpPrimitive, fn)
+	e.functionName = name
+	e.doc = doc
+	return e
+}
+
+func (b Builtin) validate() error {
+	if b.Name == "" {
+		return errors.New("builtin name must not be empty")
+	}
+
+	if b.Fn == nil {
+		return fmt.Errorf("builtin '%v' has no implementation", b.Name)
+	}
+
+	if b.MinArgs < 0 {
+		return fmt.Errorf("builtin '%v' can't take fewer than zero args", b.Name)
+	}
+
+	if b.MaxArgs != Variadic && b.MaxArgs < b.MinArgs {
+		return fmt.Errorf("builtin '%v' max args (%v) is less than min args (%v)",
+			b.Name, b.MaxArgs, b.MinArgs)
+	}
+	return nil
+}
+
+func (b Builtin) primitive() PrimitiveFunc {
+	sig := fmt.Sprintf("(%v …)", b.Name)
+	arity := ckArityRange(b.MinArgs, b.MaxArgs)
+	fn := b.Fn
+	return func(args []Expression) (Expression, error) {
+		if err := typeCheck(sig, args, arity); err != nil {
+			return NilExpression, err
+		}
+		return fn(args)
+	}
+}
+
+// defineBuiltin installs a host function in an environment's global
+// frame, shadowing any existing binding of the same name.
+func defineBuiltin(env *Environment, b Builtin) error {
+	if err := b.validate(); err != nil {
+		return err
+	}
+
+	env.Set(hSym(b.Name), NewPrimitiveExpr(b.Name, b.Doc, b.primitive()))
+	return nil
+}
+
+// Define installs a Go function accepting any number of arguments.
+func (tco TcoInterpreter) Define(name string, fn PrimitiveFunc) error {
+	return tco.DefineBuiltin(Builtin{Name: name, MaxArgs: Variadic, Fn: fn})
+}
+
+// Define installs a Go function accepting any number of arguments.
+func (naive NaiveInterpreter) Define(name string, fn PrimitiveFunc) error {
+	return naive.DefineBuiltin(Builtin{Name: name, MaxArgs: Variadic, Fn: fn})
+}
+
+// Define installs a Go function accepting any number of arguments.
+func (vm VMInterpreter) Define(name string, fn PrimitiveFunc) error {
+	return vm.DefineBuiltin(Builtin{Name: name, MaxArgs: Variadic, Fn: fn})
+}
+
+// DefineBuiltin installs a Go function whose arity is checked before
+// every call.
+func (tco TcoInterpreter) DefineBuiltin(b Builtin) error {
+	return defineBuiltin(tco.environment, b)
+}
+
+// DefineBuiltin installs a Go function whose arity is checked before
+// every call.
+func (naive NaiveInterpreter) DefineBuiltin(b Builtin) error {
+	return defineBuiltin(naive.environment, b)
+}
+
+// DefineBuiltin installs a Go function whose arity is checked before
+// every call.
+func (vm VMInterpreter) DefineBuiltin(b Builtin) error {
+	return defineBuiltin(vm.environment, b)
+}
+
+//-----------------------------------------------------------------------------
+// Implementation
+//-----------------------------------------------------------------------------
+
+func _doc(args []Expression) (Expression, error) {
+	if err := typeCheck("(doc fn)", args, ckArity(1)); err != nil {
+		return NilExpression, err
+	}
+
+	if args[0].tag != ExpPrimitive || args[0].doc == "" {
+		return NilExpression, nil
+	}
+	return hStr(args[0].doc), nil
+}
+
+func _gensym(args []Expression) (Expression, error) {
+	if err := typeCheck("(gensym [prefix])", args, ckArityRange(0, 1), ckOptString(0)); err != nil {
+		return NilExpression, err
+	}
+
+	prefix := "G__"
+	if len(args) == 1 {
+		prefix = args[0].string
+	}
+	return GenSym(prefix), nil
+}
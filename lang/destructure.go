@@ -0,0 +1,276 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"fmt"
+)
+
+// Wherever a name is bound (let, required function params, and the
+// function given to loop), a pattern can be used instead. A list (or
+// vector) pattern takes a list or vector apart:
+//
+//    (let ((ok code out) (exec! "git" "status")) ...)
+//    (let ([head & tail] lst) ...)
+//
+// A map pattern looks values up in a hash-map. It's written as a
+// hash-map literal, or as an hmap form:
+//
+//    (let ({:keys (stdout exit) :as result} (exec!! "ls")) ...)
+//    (let ((hmap :keys (stdout exit)) (exec!! "ls")) ...)
+//    (let ({status "code"} response) ...)
+//
+// `:keys` binds each name to the value under that name as a keyword,
+// symbol or string key, whichever is found first. Other entries are
+// a pattern and the key to bind it to. Missing keys are nil. Patterns
+// nest, and `_` ignores a value. A list pattern can't start with a
+// name `hmap`, as it would be a map pattern.
+
+const (
+	ignoreName = "_"
+	keysOption = ":keys"
+	asOption   = ":as"
+)
+
+// checkPattern returns an error if p is neither a name nor a well
+// formed pattern.
+func checkPattern(p Expression) error {
+	switch {
+
+	case p.IsSymbol():
+		if isMarker(p) {
+			return fmt.Errorf("'%v' is not a name", p)
+		}
+		return nil
+
+	case isMapPattern(p):
+		pairs := mapPatternPairs(p)
+		if len(pairs)%2 != 0 {
+			return fmt.Errorf("%v expects an even number of keys and patterns", p)
+		}
+		for i := 0; i < len(pairs); i += 2 {
+			opt, arg := pairs[i], pairs[i+1]
+			switch {
+			case isKeyword(opt, keysOption):
+				if !arg.IsList() && !arg.IsVector() {
+					return fmt.Errorf("'%v' in %v must be followed by a list of names", keysOption, p)
+				}
+				for _, name := range arg.list {
+					if !name.IsSymbol() || isMarker(name) {
+						return fmt.Errorf("'%v' in %v is not a name", name, p)
+					}
+				}
+			case isKeyword(opt, asOption):
+				if !arg.IsSymbol() {
+					return fmt.Errorf("'%v' in %v must be followed by a name", asOption, p)
+				}
+			default:
+				if err := checkPattern(opt); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+
+	case p.IsList() || p.IsVector():
+		elems, rest := splitRest(p)
+		for _, e := range elems {
+			if err := checkPattern(e); err != nil {
+				return err
+			}
+		}
+		if rest != nil {
+			if len(rest) != 1 {
+				return fmt.Errorf("'%v' in %v must be followed by exactly one pattern", restMarker, p)
+			}
+			return checkPattern(rest[0])
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("'%v' is not a name or pattern", p)
+	}
+}
+
+// isMapPattern is true of a hash-map literal, or an (hmap k v ...)
+// form, used as a pattern.
+func isMapPattern(p Expression) bool {
+	return p.IsHashMap() ||
+		p.IsList() && len(p.list) > 0 && p.list[0].IsSymbol() && p.list[0].symbol == "hmap"
+}
+
+// mapPatternPairs returns each option or pattern in a map pattern
+// followed by its argument.
+func mapPatternPairs(p Expression) []Expression {
+	if p.IsHashMap() {
+		return literalElems(p)
+	}
+	return p.list[1:]
+}
+
+// splitRest returns the patterns before and after `&` in a list or
+// vector pattern. The second is nil if there's no `&`.
+func splitRest(p Expression) ([]Expression, []Expression) {
+	for i, e := range p.list {
+		if e.IsSymbol() && e.symbol == restMarker {
+			return p.list[:i], p.list[i+1:]
+		}
+	}
+	return p.list, nil
+}
+
+// patternNames returns the names a well formed pattern binds, in the
+// order bindPattern binds them.
+func patternNames(p Expression) []string {
+	switch {
+
+	case p.IsSymbol():
+		if p.symbol == ignoreName {
+			return nil
+		}
+		return []string{p.symbol}
+
+	case isMapPattern(p):
+		names := make([]string, 0)
+		pairs := mapPatternPairs(p)
+		for i := 0; i < len(pairs); i += 2 {
+			opt, arg := pairs[i], pairs[i+1]
+			switch {
+			case isKeyword(opt, keysOption):
+				for _, name := range arg.list {
+					names = append(names, patternNames(name)...)
+				}
+			case isKeyword(opt, asOption):
+				names = append(names, arg.symbol)
+			default:
+				names = append(names, patternNames(opt)...)
+			}
+		}
+		return names
+
+	default:
+		names := make([]string, 0)
+		elems, rest := splitRest(p)
+		for _, e := range elems {
+			names = append(names, patternNames(e)...)
+		}
+		for _, e := range rest {
+			names = append(names, patternNames(e)...)
+		}
+		return names
+	}
+}
+
+// bindPattern binds the names in pattern to the matching parts of
+// value, which must have the pattern's shape.
+func bindPattern(frame *frameType, pattern, value Expression) error {
+	switch {
+
+	case pattern.IsSymbol():
+		if pattern.symbol != ignoreName {
+			frame.bind(pattern.symbol, value)
+		}
+		return nil
+
+	case isMapPattern(pattern):
+		return bindMap(frame, pattern, value)
+
+	case pattern.IsList() || pattern.IsVector():
+		return bindList(frame, pattern, value)
+
+	default:
+		return fmt.Errorf("'%v' is not a name or pattern", pattern)
+	}
+}
+
+func bindList(frame *frameType, pattern, value Expression) error {
+	if !value.IsList() && !value.IsVector() && !value.IsNil() {
+		return fmt.Errorf("cannot destructure %v '%v' with %v",
+			value.Type(), value, pattern)
+	}
+
+	elems, rest := splitRest(pattern)
+	values := value.list
+
+	if len(values) < len(elems) || (rest == nil && len(values) > len(elems)) {
+		expected := fmt.Sprintf("%v", len(elems))
+		if rest != nil {
+			expected = "at least " + expected
+		}
+		return fmt.Errorf("%v expects %v value(s), got %v",
+			pattern, expected, len(values))
+	}
+
+	for i, e := range elems {
+		if err := bindPattern(frame, e, values[i]); err != nil {
+			return err
+		}
+	}
+
+	if rest != nil {
+		return bindPattern(frame, rest[0], NewListExpr(append([]Expression{}, values[len(elems):]...)))
+	}
+	return nil
+}
+
+func bindMap(frame *frameType, pattern, value Expression) error {
+	if !value.IsHashMap() && !value.IsNil() {
+		return fmt.Errorf("cannot destructure %v '%v' with %v",
+			value.Type(), value, pattern)
+	}
+
+	pairs := mapPatternPairs(pattern)
+	for i := 0; i < len(pairs); i += 2 {
+		opt, arg := pairs[i], pairs[i+1]
+
+		switch {
+		case isKeyword(opt, keysOption):
+			for _, name := range arg.list {
+				found := lookupKey(value, NewKeywordExpr(name.symbol))
+				if found.IsNil() {
+					found = lookupKey(value, name)
+				}
+				if found.IsNil() {
+					found = lookupKey(value, hStr(name.symbol))
+				}
+				if err := bindPattern(frame, name, found); err != nil {
+					return err
+				}
+			}
+
+		case isKeyword(opt, asOption):
+			frame.bind(arg.symbol, value)
+
+		default:
+			if arg.IsQuote() {
+				arg = *arg.quote
+			}
+			if err := bindPattern(frame, opt, lookupKey(value, arg)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func lookupKey(m, key Expression) Expression {
+	if !m.IsHashMap() {
+		return NilExpression
+	}
+	value, _ := m.hashMap.get(key)
+	return value
+}
@@ -18,70 +18,181 @@ package lang
 
 import (
 	"fmt"
+	"strings"
 )
 
-type frameType map[string]Expression
+// frameType holds the locals bound by a call, a let or a catch, as
+// parallel lists of names and values. Each frame links to the frame
+// it was made in, so extending an environment, or capturing it for a
+// closure, shares its frames rather than copying them. The tree walking
+// interpreters look locals up by name, innermost first. Code compiled
+// for the VM is laid out ahead of time, so it addresses a local by
+// (depth, index): how many frames out it is and its slot in that frame.
+type frameType struct {
+	names  []string
+	slots  []Expression
+	parent *frameType
+}
+
+// globals is the table of top level definitions, shared by every
+// environment of an interpreter.
+type globals map[string]Expression
 
-// Environment represents bindings
+// Environment represents bindings. Environments derived from each
+// other share their globals and frames, so they belong to a single
+// interpreter, and must not be used from more than one goroutine at a
+// time.
 type Environment struct {
-	global frameType
-	frames []frameType
+	global globals
+	frame  *frameType // the innermost frame, nil at the top level
+	ns     *namespace
+}
+
+// namespace is a group of global definitions. Names defined while a
+// namespace other than the default is current are stored in the global
+// frame as ns/name, so libraries can't collide with each other or with
+// the scripts using them. Unqualified names are looked up in the
+// current namespace first, then among the default namespace's names
+// (including the builtins and Core).
+type namespace struct {
+	name    string            // "" for the default namespace
+	aliases map[string]string // alias → namespace name, from require
+}
+
+func newNamespace(name string) *namespace {
+	return &namespace{name: name, aliases: make(map[string]string)}
+}
+
+// splitQualified splits ns/name into its parts.
+func splitQualified(key string) (string, string, bool) {
+	i := strings.Index(key, "/")
+	if i <= 0 || i == len(key)-1 {
+		return "", key, false
+	}
+	return key[:i], key[i+1:], true
 }
 
 // NewEnvironment contains bindings
 func NewEnvironment(cliArgs []string) *Environment {
-	data := make(map[string]Expression, 0)
-	frames := make([]frameType, 0)
+	return newEnvironment(cliArgs, newSession(newConfig(nil)))
+}
+
+func newEnvironment(cliArgs []string, s *session) *Environment {
+	data := make(globals)
+
+	for _, group := range builtins {
+		for name, fn := range group {
+			data[name] = NewPrimitiveExpr(name, "", fn)
+		}
+	}
 
-	for name, fn := range builtins {
-		data[name] = NewExpr(ExpPrimitive, fn)
+	for _, b := range sessionBuiltins {
+		granted := s.capabilities.Allows(b.capability)
+		for name, fn := range b.group(s) {
+			if !granted {
+				fn = deniedBuiltin(name, b.capability)
+			}
+			data[name] = NewPrimitiveExpr(name, "", fn)
+		}
 	}
 
 	data["true"] = TrueExpression
 	data["false"] = FalseExpression
 	data["nil"] = NilExpression
-	data["*stdin*"] = StdinExpression
-	data["*stdout*"] = StdoutExpression
-	data["*stderr*"] = StderrExpression
+	data["*stdin*"] = newStreamHandleExpr("/dev/stdin", s.stdin)
+	data["*stdout*"] = newStreamHandleExpr("/dev/stdout", nil)
+	data["*stderr*"] = newStreamHandleExpr("/dev/stderr", nil)
 	data["*args*"] = NewStringListExpr(cliArgs)
 
-	return &Environment{global: data, frames: frames}
+	return &Environment{global: data, ns: newNamespace("")}
+}
+
+// globalKey returns the key in the global frame that a name refers to
+// from env's namespace, and whether it's bound.
+func (env *Environment) globalKey(key string) (string, bool) {
+	if ns, name, ok := splitQualified(key); ok {
+		if env.ns != nil {
+			if real, found := env.ns.aliases[ns]; found {
+				ns = real
+			}
+		}
+		if _, found := env.global[ns+"/"+name]; found {
+			return ns + "/" + name, true
+		}
+	} else if env.ns != nil && env.ns.name != "" {
+		qualified := env.ns.name + "/" + key
+		if _, found := env.global[qualified]; found {
+			return qualified, true
+		}
+	}
+
+	_, found := env.global[key]
+	return key, found
+}
+
+// qualify returns a symbol naming a global of env's namespace with
+// the namespace's name, so it refers to the same global when a macro's
+// expansion is evaluated in another namespace.
+func (env *Environment) qualify(sym Expression) Expression {
+	if env.ns == nil || env.ns.name == "" {
+		return sym
+	}
+	if _, found := env.frame.lookup(sym.symbol); found {
+		return sym
+	}
+	if key, found := env.globalKey(sym.symbol); found && key != sym.symbol {
+		return withSpanOf(NewExpr(ExpSymbol, key), sym)
+	}
+	return sym
+}
+
+// inNamespace returns a copy of env whose current namespace is ns.
+func (env *Environment) inNamespace(ns *namespace) *Environment {
+	if ns == nil || ns == env.ns {
+		return env
+	}
+	scope := env.capture()
+	scope.ns = ns
+	return scope
 }
 
 // Lookup a value in the environment
 func (env *Environment) Lookup(key string) (bool, Expression) {
-	for i := len(env.frames) - 1; i >= 0; i-- {
-		value, found := env.frames[i].lookup(key)
-		if found {
-			return true, value
-		}
+	if value, found := env.frame.lookup(key); found {
+		return true, *value
 	}
 
-	value, found := env.global.lookup(key)
-	if found {
-		return true, value
+	if key, found := env.globalKey(key); found {
+		return true, env.global[key]
 	}
 	return false, NilExpression
 }
 
-// Set a value in the global environment frame
+// Set a value in the global environment frame, in the current
+// namespace.
 func (env *Environment) Set(key, value Expression) {
-	env.global[key.symbol] = value
+	name := key.symbol
+	if _, _, qualified := splitQualified(name); !qualified && env.ns != nil && env.ns.name != "" {
+		name = env.ns.name + "/" + name
+	}
+	env.global[name] = value
 }
 
-// Clone returns a copy of the environment
+// Clone returns a copy of the environment, with copies of its frames,
+// for functions restored from a snapshot into another interpreter.
 func (env *Environment) Clone() *Environment {
-	frames := copyFrames(env.frames)
 	return &Environment{
-		frames: frames,
+		frame:  env.frame.copyAll(),
 		global: env.global,
+		ns:     env.ns,
 	}
 }
 
 // Dump stack frames
 func (env *Environment) Dump() {
-	for i, f := range env.frames {
-		for k, v := range f {
+	for i, f := 0, env.frame; f != nil; i, f = i+1, f.parent {
+		for j, k := range f.names {
+			v := f.slots[j]
 			fmt.Printf(" frame[%v]: `%v` → `%v`\n", i, k, v)
 			if v.IsLambda() {
 				v.functionEnv.Dump()
@@ -90,50 +201,113 @@ func (env *Environment) Dump() {
 	}
 }
 
-// ExtendEnvironment returns a copy of the environment with new bindings.
+// ExtendEnvironment returns an environment with new bindings, in a
+// frame linked to env's.
 func (env *Environment) ExtendEnvironment(params Expression, args []Expression) *Environment {
-	clone := env.Clone()
-
-	frame := make(frameType, 0)
+	scope, frame := env.extend()
 	for i := 0; i < len(args); i++ {
-		frame[params.list[i].symbol] = args[i]
+		frame.bind(params.list[i].symbol, args[i])
 	}
+	return scope
+}
+
+// capture returns the environment a function closes over. Unlike a
+// clone, it shares env's frames, so a variable changed with set! is
+// changed for every closure and scope that can see it.
+func (env *Environment) capture() *Environment {
+	return &Environment{global: env.global, frame: env.frame, ns: env.ns}
+}
 
-	clone.frames = append(clone.frames, frame)
-	return clone
+// extend returns an environment sharing env's frames with a new, empty
+// frame for bindings.
+func (env *Environment) extend() (*Environment, *frameType) {
+	scope := env.capture()
+	scope.frame = newFrame(env.frame)
+	return scope, scope.frame
 }
 
-func (frame frameType) lookup(key string) (Expression, bool) {
-	value, found := frame[key]
-	if !found {
-		return NilExpression, false
+// assign rebinds the innermost variable named key, returning false if
+// there isn't one.
+func (env *Environment) assign(key string, value Expression) bool {
+	if slot, found := env.frame.lookup(key); found {
+		*slot = value
+		return true
 	}
-	return value, true
+
+	if key, found := env.globalKey(key); found {
+		env.global[key] = value
+		return true
+	}
+	return false
 }
 
 // Replace sets an env binding in the current frame
 func (env *Environment) Replace(key, value Expression) {
-	if len(env.frames) == 0 {
+	if env.frame == nil {
 		env.Set(key, value)
 		return
 	}
+	env.frame.bind(key.symbol, value)
+}
+
+//-----------------------------------------------------------------------------
+// Frames
+//-----------------------------------------------------------------------------
 
-	lastFrame := env.frames[len(env.frames)-1]
-	lastFrame[key.symbol] = value
+func newFrame(parent *frameType) *frameType {
+	return &frameType{parent: parent}
 }
 
-func copyFrame(frame frameType) frameType {
-	newFrame := make(frameType, 0)
-	for k, v := range frame {
-		newFrame[k] = v
+// index returns the slot of name in the frame, or -1. A frame laid
+// out by the VM may bind a name more than once, the later binding
+// being the inner one.
+func (frame *frameType) index(name string) int {
+	for i := len(frame.names) - 1; i >= 0; i-- {
+		if frame.names[i] == name {
+			return i
+		}
 	}
-	return newFrame
+	return -1
 }
 
-func copyFrames(frames []frameType) []frameType {
-	newFrames := make([]frameType, 0)
-	for _, frame := range frames {
-		newFrames = append(newFrames, copyFrame(frame))
+// bind binds name in the frame, replacing any value it's bound to.
+func (frame *frameType) bind(name string, value Expression) {
+	if i := frame.index(name); i >= 0 {
+		frame.slots[i] = value
+		return
+	}
+	frame.names = append(frame.names, name)
+	frame.slots = append(frame.slots, value)
+}
+
+// lookup returns the innermost slot binding key in frame or the frames
+// it's linked to.
+func (frame *frameType) lookup(key string) (*Expression, bool) {
+	for f := frame; f != nil; f = f.parent {
+		if i := f.index(key); i >= 0 {
+			return &f.slots[i], true
+		}
+	}
+	return nil, false
+}
+
+// at returns the slot at a compiled (depth, index) address.
+func (frame *frameType) at(depth, index int) *Expression {
+	f := frame
+	for ; depth > 0; depth-- {
+		f = f.parent
+	}
+	return &f.slots[index]
+}
+
+// copyAll returns a copy of the frame and the frames it's linked to.
+func (frame *frameType) copyAll() *frameType {
+	if frame == nil {
+		return nil
+	}
+	return &frameType{
+		names:  append([]string{}, frame.names...),
+		slots:  append([]Expression{}, frame.slots...),
+		parent: frame.parent.copyAll(),
 	}
-	return newFrames
 }
@@ -0,0 +1,209 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"fmt"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+// Source positions
+//-----------------------------------------------------------------------------
+
+// Pos is a position in haki source. Lines and columns count from 1.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+// IsValid returns true if the position is known.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%v:%v", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%v:%v:%v", p.File, p.Line, p.Col)
+}
+
+// source is a chunk of text given to a reader, kept so diagnostics
+// can quote the offending line.
+type source struct {
+	name  string
+	text  string
+	first int // line number of the chunk's first line
+}
+
+// mark is where a rune was read from.
+type mark struct {
+	src  *source
+	line int
+	col  int
+}
+
+func (m mark) pos() Pos {
+	if m.src == nil {
+		return Pos{}
+	}
+	return Pos{File: m.src.name, Line: m.line, Col: m.col}
+}
+
+// Span is the extent of a form in its source.
+type Span struct {
+	Start Pos
+	End   Pos
+	src   *source
+}
+
+func newSpan(start, end mark) *Span {
+	return &Span{Start: start.pos(), End: end.pos(), src: start.src}
+}
+
+// IsValid returns true if the span's position is known.
+func (s Span) IsValid() bool {
+	return s.Start.IsValid()
+}
+
+// Text returns the source line the span starts on, if it's known.
+func (s Span) Text() string {
+	if s.src == nil {
+		return ""
+	}
+
+	lines := strings.Split(s.src.text, "\n")
+	n := s.Start.Line - s.src.first
+	if n < 0 || n >= len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[n], "\r")
+}
+
+//-----------------------------------------------------------------------------
+// Errors
+//-----------------------------------------------------------------------------
+
+// Frame is a haki function call in progress when an error occurred.
+type Frame struct {
+	Name string // the function's name, or "fn" for lambdas
+	Call Span   // where it was called
+}
+
+// Error is returned when reading or evaluating haki fails.
+type Error struct {
+	Message string
+	Span    Span    // the form being evaluated, if its position is known
+	Stack   []Frame // innermost call first
+	Err     error   // the underlying error, if any
+}
+
+func (e *Error) Error() string {
+	if !e.Span.IsValid() {
+		return e.Message
+	}
+	return fmt.Sprintf("%v: %v", e.Span.Start, e.Message)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Diagnostic formats the error as a compiler would, quoting the
+// offending source line and listing the calls that led to it.
+func (e *Error) Diagnostic() string {
+	var b strings.Builder
+
+	if e.Span.IsValid() {
+		fmt.Fprintf(&b, "%v: error: %v\n", e.Span.Start, e.Message)
+	} else {
+		fmt.Fprintf(&b, "error: %v\n", e.Message)
+	}
+
+	if text := e.Span.Text(); text != "" {
+		start, end := e.Span.Start, e.Span.End
+		gutter := fmt.Sprintf("%5d | ", start.Line)
+
+		fmt.Fprintf(&b, "%v%v\n", gutter, text)
+		fmt.Fprintf(&b, "%v| %v^", strings.Repeat(" ", len(gutter)-2), indent(text, start.Col))
+		if end.Line == start.Line && end.Col > start.Col {
+			b.WriteString(strings.Repeat("~", end.Col-start.Col))
+		}
+		b.WriteString("\n")
+	}
+
+	for _, f := range e.Stack {
+		if f.Call.IsValid() {
+			fmt.Fprintf(&b, "  in '%v', called from %v\n", f.Name, f.Call.Start)
+		} else {
+			fmt.Fprintf(&b, "  in '%v'\n", f.Name)
+		}
+	}
+
+	return b.String()
+}
+
+// indent returns whitespace reaching the given column of text, keeping
+// tabs so a caret lines up however they're displayed.
+func indent(text string, col int) string {
+	pad := make([]rune, 0, col)
+	for i, c := range []rune(text) {
+		if i >= col-1 {
+			break
+		}
+		if c == '\t' {
+			pad = append(pad, '\t')
+		} else {
+			pad = append(pad, ' ')
+		}
+	}
+	return string(pad)
+}
+
+// annotate converts err to an *Error, giving it the span of the form
+// being evaluated if it doesn't already have a more precise one.
+func annotate(err error, at *Span) *Error {
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Message: err.Error(), Err: err}
+	}
+	if !e.Span.IsValid() && at != nil {
+		e.Span = *at
+	}
+	return e
+}
+
+// withFrame records that err passed out of a call to fn.
+func withFrame(err error, fn Expression, call *Span) *Error {
+	e := annotate(err, call)
+
+	name := fn.functionName
+	if fn.IsLambda() {
+		name = "fn"
+	}
+
+	f := Frame{Name: name}
+	if call != nil {
+		f.Call = *call
+	}
+
+	e.Stack = append(e.Stack, f)
+	return e
+}
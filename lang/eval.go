@@ -16,13 +16,23 @@
 
 package lang
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 // Interpreter is something that evaluates
 type Interpreter interface {
+	Apply(fn Expression, args []Expression) (Expression, error)
+	Call(name string, args ...Expression) (Expression, error)
+	Define(name string, fn PrimitiveFunc) error
+	DefineBuiltin(b Builtin) error
 	Evaluate(env *Environment, expr Expression) (Expression, error)
+	EvaluateContext(ctx context.Context, env *Environment, expr Expression) (Expression, error)
 	Execute(form string) (Expression, error)
 	Run(reader *Reader) (Expression, error)
+	RunProgram(p *Program) (Expression, error)
+	RunContext(ctx context.Context, reader *Reader) (Expression, error)
 	SetEnv(key, value string)
 	SetVersionInfo(vers, commit, date string)
 }
@@ -31,12 +41,23 @@ type Interpreter interface {
 type TcoInterpreter struct {
 	parser      *Parser
 	environment *Environment
+	session     *session
 }
 
 // NaiveInterpreter is fully recursive
 type NaiveInterpreter struct {
 	parser      *Parser
 	environment *Environment
+	session     *session
+}
+
+// VMInterpreter compiles each form to bytecode for a stack machine
+// (see compile.go), and runs that.
+type VMInterpreter struct {
+	parser      *Parser
+	environment *Environment
+	session     *session
+	machine     *machine
 }
 
 // Type represents a type of evaluator
@@ -46,34 +67,47 @@ type Type int
 const (
 	TCO Type = iota
 	Naive
+	VM
 )
 
 // NewInterpreter returns an evaluator for the repl (no cli args)
-func NewInterpreter(kind Type) Interpreter {
-	return NewScriptInterpreter(kind, []string{})
+func NewInterpreter(kind Type, opts ...Option) Interpreter {
+	return NewScriptInterpreter(kind, []string{}, opts...)
 }
 
 // NewScriptInterpreter returns an evaluator for scripts
-func NewScriptInterpreter(kind Type, cliArgs []string) Interpreter {
-	env := NewEnvironment(cliArgs)
+func NewScriptInterpreter(kind Type, cliArgs []string, opts ...Option) Interpreter {
+	c := newConfig(opts)
+	s := newSession(c)
+	env := newEnvironment(cliArgs, s)
 	env.Set(hStr("*foo*"), hStr("bar"))
+	c.snapshot.restore(env)
 	switch kind {
 	case TCO:
 		return TcoInterpreter{
-			// environment: NewEnvironment(cliArgs),
 			environment: env,
 			parser:      NewParser(),
+			session:     s,
+		}
+	case VM:
+		return VMInterpreter{
+			environment: env,
+			parser:      NewParser(),
+			session:     s,
+			machine:     newMachine(),
 		}
 	default:
 		return NaiveInterpreter{
-			environment: NewEnvironment(cliArgs),
+			environment: env,
 			parser:      NewParser(),
+			session:     s,
 		}
 	}
 }
 
 // Execute a Haki expression.
 func (tco TcoInterpreter) Execute(form string) (Expression, error) {
+	defer tco.session.begin()()
 
 	tokens, err := Tokenize(form)
 	if err != nil {
@@ -87,11 +121,16 @@ func (tco TcoInterpreter) Execute(form string) (Expression, error) {
 		return NilExpression, err
 	}
 
+	if expr, _, err = expandAll(tco, tco.environment, expr); err != nil {
+		return NilExpression, err
+	}
+
 	return tco.Evaluate(tco.environment, expr)
 }
 
 // Execute a Haki expression.
 func (naive NaiveInterpreter) Execute(form string) (Expression, error) {
+	defer naive.session.begin()()
 
 	tokens, err := Tokenize(form)
 	if err != nil {
@@ -104,17 +143,209 @@ func (naive NaiveInterpreter) Execute(form string) (Expression, error) {
 	if err != nil {
 		return NilExpression, err
 	}
+
+	if expr, _, err = expandAll(naive, naive.environment, expr); err != nil {
+		return NilExpression, err
+	}
 	return naive.Evaluate(naive.environment, expr)
 }
 
+// Execute a Haki expression.
+func (vm VMInterpreter) Execute(form string) (Expression, error) {
+	defer vm.session.begin()()
+
+	tokens, err := Tokenize(form)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	vm.parser.Reset(tokens)
+
+	expr, err := vm.parser.Parse()
+	if err != nil {
+		return NilExpression, err
+	}
+
+	if expr, _, err = expandAll(vm, vm.environment, expr); err != nil {
+		return NilExpression, err
+	}
+	return vm.Evaluate(vm.environment, expr)
+}
+
 // Run executes all the forms in a reader (a script)
 func (tco TcoInterpreter) Run(reader *Reader) (Expression, error) {
-	return runner(tco, reader)
+	defer tco.session.begin()()
+	return runner(tco, tco.environment, reader)
 }
 
 // Run executes all the forms in a reader (a script)
 func (naive NaiveInterpreter) Run(reader *Reader) (Expression, error) {
-	return runner(naive, reader)
+	defer naive.session.begin()()
+	return runner(naive, naive.environment, reader)
+}
+
+// Run executes all the forms in a reader (a script)
+func (vm VMInterpreter) Run(reader *Reader) (Expression, error) {
+	defer vm.session.begin()()
+	return runner(vm, vm.environment, reader)
+}
+
+// RunContext executes all the forms in a reader, stopping with a
+// *CancelledError if ctx is done first.
+func (tco TcoInterpreter) RunContext(ctx context.Context, reader *Reader) (Expression, error) {
+	defer tco.session.withContext(ctx)()
+	return tco.Run(reader)
+}
+
+// RunContext executes all the forms in a reader, stopping with a
+// *CancelledError if ctx is done first.
+func (naive NaiveInterpreter) RunContext(ctx context.Context, reader *Reader) (Expression, error) {
+	defer naive.session.withContext(ctx)()
+	return naive.Run(reader)
+}
+
+// RunContext executes all the forms in a reader, stopping with a
+// *CancelledError if ctx is done first.
+func (vm VMInterpreter) RunContext(ctx context.Context, reader *Reader) (Expression, error) {
+	defer vm.session.withContext(ctx)()
+	return vm.Run(reader)
+}
+
+// EvaluateContext evaluates an expression, stopping with a
+// *CancelledError if ctx is done first.
+func (tco TcoInterpreter) EvaluateContext(ctx context.Context, env *Environment, expr Expression) (Expression, error) {
+	defer tco.session.begin()()
+	defer tco.session.withContext(ctx)()
+	return tco.Evaluate(env, expr)
+}
+
+// EvaluateContext evaluates an expression, stopping with a
+// *CancelledError if ctx is done first.
+func (naive NaiveInterpreter) EvaluateContext(ctx context.Context, env *Environment, expr Expression) (Expression, error) {
+	defer naive.session.begin()()
+	defer naive.session.withContext(ctx)()
+	return naive.Evaluate(env, expr)
+}
+
+// EvaluateContext evaluates an expression, stopping with a
+// *CancelledError if ctx is done first.
+func (vm VMInterpreter) EvaluateContext(ctx context.Context, env *Environment, expr Expression) (Expression, error) {
+	defer vm.session.begin()()
+	defer vm.session.withContext(ctx)()
+	return vm.Evaluate(env, expr)
+}
+
+// Call invokes the function bound to name with already evaluated
+// args, letting a host use script functions as callbacks.
+func (tco TcoInterpreter) Call(name string, args ...Expression) (Expression, error) {
+	fn, err := lookupInvokable(tco.environment, name)
+	if err != nil {
+		return NilExpression, err
+	}
+	return tco.Apply(fn, args)
+}
+
+// Call invokes the function bound to name with already evaluated
+// args, letting a host use script functions as callbacks.
+func (naive NaiveInterpreter) Call(name string, args ...Expression) (Expression, error) {
+	fn, err := lookupInvokable(naive.environment, name)
+	if err != nil {
+		return NilExpression, err
+	}
+	return naive.Apply(fn, args)
+}
+
+// Call invokes the function bound to name with already evaluated
+// args, letting a host use script functions as callbacks.
+func (vm VMInterpreter) Call(name string, args ...Expression) (Expression, error) {
+	fn, err := lookupInvokable(vm.environment, name)
+	if err != nil {
+		return NilExpression, err
+	}
+	return vm.Apply(fn, args)
+}
+
+// Apply invokes a function value with already evaluated args.
+func (tco TcoInterpreter) Apply(fn Expression, args []Expression) (Expression, error) {
+	defer tco.session.begin()()
+	if fn.IsPrimitive() {
+		return tco.session.invokePrimitive(fn, args)
+	}
+
+	if ok, err := isValidArity(fn, args); !ok {
+		return NilExpression, err
+	}
+
+	env, err := fn.functionEnv.bindArgs(fn, args, tco.Evaluate)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	if err := tco.session.enter(); err != nil {
+		return NilExpression, err
+	}
+	defer tco.session.leave()
+	return tco.evaluate(env, *fn.functionBody, fn)
+}
+
+// Apply invokes a function value with already evaluated args.
+func (naive NaiveInterpreter) Apply(fn Expression, args []Expression) (Expression, error) {
+	defer naive.session.begin()()
+	if fn.IsPrimitive() {
+		return naive.session.invokePrimitive(fn, args)
+	}
+
+	if ok, err := isValidArity(fn, args); !ok {
+		return NilExpression, err
+	}
+
+	// Named functions see the caller's environment, which for a host
+	// is the top level.
+	env := fn.functionEnv
+	if fn.IsFunction() {
+		env = naive.environment.inNamespace(fn.functionEnv.ns)
+	}
+
+	env, err := env.bindArgs(fn, args, naive.Evaluate)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	if err := naive.session.enter(); err != nil {
+		return NilExpression, err
+	}
+	defer naive.session.leave()
+	return naive.Evaluate(env, *fn.functionBody)
+}
+
+// Apply invokes a function value with already evaluated args.
+func (vm VMInterpreter) Apply(fn Expression, args []Expression) (Expression, error) {
+	defer vm.session.begin()()
+	if fn.IsPrimitive() {
+		return vm.session.invokePrimitive(fn, args)
+	}
+
+	p, env, err := vm.prepare(fn, args)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	if err := vm.session.enter(); err != nil {
+		return NilExpression, err
+	}
+	defer vm.session.leave()
+	return vm.execute(fn, p, env, len(args), nil)
+}
+
+func lookupInvokable(env *Environment, name string) (Expression, error) {
+	found, fn := env.Lookup(name)
+	if !found {
+		return nilExpr("function '%v' not defined", name)
+	}
+	if !fn.IsInvokable() {
+		return nilExpr("'%v' (%v) is not invokable", name, fn.Type())
+	}
+	return fn, nil
 }
 
 // SetVersionInfo installs build version info into the environment
@@ -131,6 +362,13 @@ func (naive NaiveInterpreter) SetVersionInfo(vers, commit, date string) {
 	naive.SetEnv("*haki-build-date*", date)
 }
 
+// SetVersionInfo installs build version info into the environment
+func (vm VMInterpreter) SetVersionInfo(vers, commit, date string) {
+	vm.SetEnv("*haki-version*", vers)
+	vm.SetEnv("*haki-git-commit*", commit)
+	vm.SetEnv("*haki-build-date*", date)
+}
+
 // SetEnv allows you to preload the environment
 func (tco TcoInterpreter) SetEnv(key, value string) {
 	tco.environment.Set(hSym(key), hStr(value))
@@ -141,15 +379,31 @@ func (naive NaiveInterpreter) SetEnv(key, value string) {
 	naive.environment.Set(hStr(key), hStr(value))
 }
 
-func runner(interpreter Interpreter, reader *Reader) (Expression, error) {
-	forms, err := reader.GetForms()
+// SetEnv allows you to preload the environment
+func (vm VMInterpreter) SetEnv(key, value string) {
+	vm.environment.Set(hSym(key), hStr(value))
+}
+
+func runner(interpreter Interpreter, env *Environment, reader *Reader) (Expression, error) {
+	forms, err := reader.readForms()
 	if err != nil {
 		return NilExpression, err
 	}
 
+	parser := NewParser()
+
 	var result Expression
 	for _, form := range forms {
-		result, err = interpreter.Execute(form)
+		expr, err := parser.parseForm(form)
+		if err != nil {
+			return NilExpression, err
+		}
+
+		if expr, _, err = expandAll(interpreter, env, expr); err != nil {
+			return NilExpression, err
+		}
+
+		result, err = interpreter.Evaluate(env, expr)
 		if err != nil {
 			return NilExpression, err
 		}
@@ -21,13 +21,26 @@ import (
 	"fmt"
 )
 
-func (x NaiveInterpreter) apply(env *Environment, op Expression, args []Expression) (Expression, error) {
+func (x NaiveInterpreter) apply(env *Environment, op Expression, args []Expression, call *Span) (Expression, error) {
+
+	if err := x.session.checkContext(); err != nil {
+		return NilExpression, err
+	}
 
 	theOp, err := x.Evaluate(env, op)
 	if err != nil {
 		return NilExpression, err
 	}
 
+	if theOp.IsMacro() {
+		form := NewListExpr(append([]Expression{op}, args...))
+		form.span = call
+		if form, err = expandMacro(x, theOp, form); err != nil {
+			return NilExpression, err
+		}
+		return x.Evaluate(env, form)
+	}
+
 	argv := make([]Expression, 0)
 	for _, a := range args {
 		param, err := x.Evaluate(env, a)
@@ -39,28 +52,40 @@ func (x NaiveInterpreter) apply(env *Environment, op Expression, args []Expressi
 
 	// Primitive operator
 	if theOp.IsPrimitive() {
-		return theOp.InvokePrimitive(argv)
+		ret, err := theOp.InvokePrimitive(argv)
+		if err != nil {
+			return ret, err
+		}
+		return ret, x.session.checkSize(ret)
 	}
 
-	argc := len(args)
-	paramc := len(theOp.functionParams.list)
-	if argc != paramc {
-		return nilExpr("function '%v' takes %v param(s), you provided %v", theOp.functionName, paramc, argc)
+	if ok, err := isValidArity(theOp, argv); !ok {
+		return NilExpression, err
 	}
 
-	// Global function
-	if theOp.IsFunction() {
-		newEnv := env.ExtendEnvironment(*theOp.functionParams, argv)
-		return x.Evaluate(newEnv, *theOp.functionBody)
+	var newEnv *Environment
+	switch {
+	case theOp.IsFunction(): // Global function
+		newEnv, err = env.inNamespace(theOp.functionEnv.ns).bindArgs(theOp, argv, x.Evaluate)
+	case theOp.IsLambda(): // Anonymous (lambda) function
+		newEnv, err = theOp.functionEnv.bindArgs(theOp, argv, x.Evaluate)
+	default:
+		return nilExpr("function not found: '%v'", theOp)
+	}
+	if err != nil {
+		return NilExpression, err
 	}
 
-	// Anonymous (lambda) function
-	if theOp.IsLambda() {
-		newEnv := theOp.functionEnv.ExtendEnvironment(*theOp.functionParams, argv)
-		return x.Evaluate(newEnv, *theOp.functionBody)
+	if err := x.session.enter(); err != nil {
+		return NilExpression, err
 	}
+	defer x.session.leave()
 
-	return nilExpr("function not found: '%v'", theOp)
+	ret, err := x.Evaluate(newEnv, *theOp.functionBody)
+	if err != nil {
+		return NilExpression, withFrame(err, theOp, call)
+	}
+	return ret, nil
 }
 
 func (x NaiveInterpreter) evalIf(env *Environment, exprs Expression) (Expression, error) {
@@ -111,6 +136,25 @@ func (x NaiveInterpreter) evalDo(env *Environment, exprs Expression) (Expression
 	return result, err
 }
 
+// evalBranch returns the form chosen by a cond, case, when or unless
+// expression, or false if expr isn't one.
+func (x NaiveInterpreter) evalBranch(env *Environment, expr Expression) (Expression, bool, error) {
+	var form Expression
+	var err error
+
+	switch expr.Head().symbol {
+	case "cond":
+		form, err = evalCond(x, env, expr.Tail())
+	case "case":
+		form, err = evalCase(x, env, expr.Tail())
+	case "when", "unless":
+		form, err = evalWhen(x, env, expr.Tail(), expr.Head().symbol == "when")
+	default:
+		return NilExpression, false, nil
+	}
+	return form, true, err
+}
+
 // NOTE: This has diverged from the TCO version
 func (x NaiveInterpreter) evalLet(env *Environment, clauses Expression, body Expression) (Expression, error) {
 
@@ -122,21 +166,23 @@ func (x NaiveInterpreter) evalLet(env *Environment, clauses Expression, body Exp
 		return nilExpr("let bindings must contain an even number of left/right pairs")
 	}
 
-	params := make([]Expression, 0)
-	args := make([]Expression, 0)
+	newEnv, frame := env.extend()
 
 	for i := 0; i < clauses.Size(); i = i + 2 {
 		param := clauses.list[i]
+		if err := checkPattern(param); err != nil {
+			return NilExpression, err
+		}
+
 		arg, err := x.Evaluate(env, clauses.list[i+1])
 		if err != nil {
 			return NilExpression, err
 		}
 
-		params = append(params, param)
-		args = append(args, arg)
+		if err := bindPattern(frame, param, arg); err != nil {
+			return NilExpression, annotate(err, param.span)
+		}
 	}
-
-	newEnv := env.ExtendEnvironment(NewExpr(ExpList, params), args)
 	doBlock := WrapImplicitDo(body.list)
 
 	return x.Evaluate(newEnv, doBlock)
@@ -207,6 +253,10 @@ func (x NaiveInterpreter) evalFunction(env *Environment, name Expression, params
 	}
 
 	f := NewFunctionExpr(env, name, params, body)
+	if f.signature.err != nil {
+		return NilExpression, f.signature.err
+	}
+
 	env.Set(name, f)
 	return f, nil
 }
@@ -219,11 +269,24 @@ func (x NaiveInterpreter) evalLambda(env *Environment, params Expression, body E
 
 	name := GenSym("fn") // necessary?
 	f := NewLambdaExpr(env, name, params, body.Head())
+	if f.signature.err != nil {
+		return NilExpression, f.signature.err
+	}
 	return f, nil
 }
 
 // Evaluate an expression
-func (x NaiveInterpreter) Evaluate(env *Environment, expr Expression) (Expression, error) {
+func (x NaiveInterpreter) Evaluate(env *Environment, expr Expression) (result Expression, err error) {
+
+	defer func() {
+		if err != nil {
+			err = annotate(err, expr.span)
+		}
+	}()
+
+	if err := x.session.step(); err != nil {
+		return NilExpression, err
+	}
 
 	if expr.IsSymbol() {
 		found, value := env.Lookup(expr.symbol)
@@ -237,6 +300,10 @@ func (x NaiveInterpreter) Evaluate(env *Environment, expr Expression) (Expressio
 		return *expr.quote, nil
 	}
 
+	if expr.IsHashMap() || expr.IsVector() || expr.IsSet() {
+		return evalCollection(x, env, expr)
+	}
+
 	if expr.IsAtom() {
 		return expr, nil
 	}
@@ -245,9 +312,22 @@ func (x NaiveInterpreter) Evaluate(env *Environment, expr Expression) (Expressio
 		if expr.StartsWith("do") {
 			return x.evalDo(env, expr.Tail())
 		}
+		if expr.StartsWith("let") && expr.Tail().Head().IsSymbol() {
+			letEnv, body, err := evalNamedLet(x, env, expr.Tail())
+			if err != nil {
+				return NilExpression, err
+			}
+			return x.Evaluate(letEnv, body)
+		}
 		if expr.StartsWith("let") {
 			return x.evalLet(env, expr.Tail().Head(), expr.Tail().Tail())
 		}
+		if form, ok, err := x.evalBranch(env, expr); ok {
+			if err != nil {
+				return NilExpression, err
+			}
+			return x.Evaluate(env, form)
+		}
 		if expr.StartsWith("and") {
 			return x.evalAnd(env, expr.Tail())
 		}
@@ -261,6 +341,36 @@ func (x NaiveInterpreter) Evaluate(env *Environment, expr Expression) (Expressio
 			def := expr.Tail()
 			return x.evalDef(env, def.Head(), def.Tail())
 		}
+		if expr.StartsWith("defmacro") {
+			return evalDefmacro(env, expr.Tail())
+		}
+		if expr.StartsWith("quasiquote") {
+			return evalQuasiquote(x, env, expr.Tail())
+		}
+		if expr.StartsWith("set!") {
+			return evalSet(x, env, expr.Tail())
+		}
+		if expr.StartsWith("swap!") {
+			return evalSwap(x, env, expr.Tail())
+		}
+		if expr.StartsWith("try") {
+			return evalTry(x, env, expr.Tail())
+		}
+		if expr.StartsWith("ns") {
+			return evalNs(env, expr.Tail())
+		}
+		if expr.StartsWith("require") {
+			return evalRequire(x, env, expr)
+		}
+		if expr.StartsWith("load") {
+			return evalLoad(x, env, expr)
+		}
+		if expr.StartsWith("macroexpand") {
+			return evalMacroexpand(x, env, expr.Tail(), false)
+		}
+		if expr.StartsWith("macroexpand-1") {
+			return evalMacroexpand(x, env, expr.Tail(), true)
+		}
 		if expr.StartsWith("defun") {
 			name := expr.Tail().Head()
 			params := expr.Tail().Tail().Head()
@@ -272,7 +382,7 @@ func (x NaiveInterpreter) Evaluate(env *Environment, expr Expression) (Expressio
 			body := expr.Tail().Tail()
 			return x.evalLambda(env, params, body)
 		}
-		return x.apply(env, expr.Head(), expr.Tail().list)
+		return x.apply(env, expr.Head(), expr.Tail().list, expr.span)
 	}
 
 	return NilExpression, fmt.Errorf("unable to eval expression [%v]", expr)
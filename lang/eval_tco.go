@@ -35,18 +35,26 @@ func (x TcoInterpreter) evalList(env *Environment, args []Expression) ([]Express
 
 func isValidArity(fn Expression, args []Expression) (bool, error) {
 
-	if !fn.IsInvokable() {
+	if !fn.IsInvokable() && !fn.IsMacro() {
 		return false, fmt.Errorf("fn '%v' (%v) is not invokable", fn, fn.Type())
 	}
 
+	if fn.signature.err != nil {
+		return false, fn.signature.err
+	}
+
 	argc := len(args)
-	paramc := len(fn.functionParams.list)
-	if argc == paramc {
+	if fn.signature.accepts(argc) {
 		return true, nil
 	}
 
-	return false, fmt.Errorf("fn '%v' takes %v param(s), you provided %v",
-		fn.functionName, paramc, argc)
+	kind := "fn"
+	if fn.IsMacro() {
+		kind = "macro"
+	}
+
+	return false, fmt.Errorf("%v '%v' takes %v param(s), you provided %v",
+		kind, fn.functionName, fn.signature.describeArity(), argc)
 }
 
 //-----------------------------------------------------------------------------
@@ -60,7 +68,10 @@ func (x TcoInterpreter) evalLoop(env *Environment, args Expression) (Expression,
 		return NilExpression, err
 	}
 
-	fn := args.list[0]
+	fn, err := x.Evaluate(env, args.list[0])
+	if err != nil {
+		return NilExpression, err
+	}
 
 	lst, err := x.Evaluate(env, args.list[1])
 	if err != nil {
@@ -68,7 +79,7 @@ func (x TcoInterpreter) evalLoop(env *Environment, args Expression) (Expression,
 	}
 
 	for _, e := range lst.list {
-		_, err := x.Evaluate(env, NewListExpr([]Expression{fn, e}))
+		_, err := x.Apply(fn, []Expression{e})
 		if err != nil {
 			return NilExpression, err
 		}
@@ -87,7 +98,10 @@ func (x TcoInterpreter) evalLoopIndex(env *Environment, args Expression) (Expres
 		return NilExpression, err
 	}
 
-	fn := args.list[0]
+	fn, err := x.Evaluate(env, args.list[0])
+	if err != nil {
+		return NilExpression, err
+	}
 
 	lst, err := x.Evaluate(env, args.list[1])
 	if err != nil {
@@ -95,7 +109,7 @@ func (x TcoInterpreter) evalLoopIndex(env *Environment, args Expression) (Expres
 	}
 
 	for i, e := range lst.list {
-		_, err := x.Evaluate(env, NewListExpr([]Expression{fn, NewIntExpr(int64(i)), e}))
+		_, err := x.Apply(fn, []Expression{NewIntExpr(int64(i)), e})
 		if err != nil {
 			return NilExpression, err
 		}
@@ -152,17 +166,32 @@ func (x TcoInterpreter) evalLet(env *Environment, clauses, body Expression) (*En
 		return env, body, nil
 	}
 
-	bindingNames := make([]Expression, 0)
-	bindingVals := make([]Expression, 0)
+	newEnv, frame := env.extend()
 
 	for i := 0; i < clauses.Size(); i += 2 {
 		name := clauses.list[i]
 		val := clauses.list[i+1]
-		bindingNames = append(bindingNames, name)
-		bindingVals = append(bindingVals, NewThunkExpr(val))
-	}
 
-	newEnv := env.ExtendEnvironment(NewListExpr(bindingNames), bindingVals)
+		if name.IsSymbol() {
+			frame.bind(name.symbol, NewThunkExpr(val))
+			continue
+		}
+
+		// Patterns can't be bound lazily, since the names they bind
+		// aren't known until the value's been taken apart.
+		if err := checkPattern(name); err != nil {
+			return env, NilExpression, err
+		}
+
+		value, err := x.Evaluate(newEnv, val)
+		if err != nil {
+			return env, NilExpression, err
+		}
+
+		if err := bindPattern(frame, name, value); err != nil {
+			return env, NilExpression, annotate(err, name.span)
+		}
+	}
 
 	doBlock := WrapImplicitDo(body.list)
 	return newEnv, doBlock, nil
@@ -277,6 +306,10 @@ func (x TcoInterpreter) evalDefun(env *Environment, name, params Expression, bod
 	}
 
 	f := NewFunctionExpr(env, name, params, body)
+	if f.signature.err != nil {
+		return NilExpression, f.signature.err
+	}
+
 	env.Set(name, f)
 	return f, nil
 }
@@ -287,6 +320,9 @@ func (x TcoInterpreter) evalLambda(env *Environment, params, body Expression) (E
 	}
 
 	f := NewLambdaExpr(env, GenSym("fn"), params, body.Head())
+	if f.signature.err != nil {
+		return NilExpression, f.signature.err
+	}
 	return f, nil
 }
 
@@ -296,9 +332,39 @@ func (x TcoInterpreter) evalLambda(env *Environment, params, body Expression) (E
 
 // Evaluate an expression in an environment, returning an expression.
 func (x TcoInterpreter) Evaluate(env *Environment, expr Expression) (Expression, error) {
-	var err error
+	return x.evaluate(env, expr, NilExpression)
+}
+
+// evaluate evaluates expr, the body of fn if fn is a function whose
+// call has already been counted against the depth limit.
+func (x TcoInterpreter) evaluate(env *Environment, expr Expression, fn Expression) (result Expression, err error) {
+
+	// The innermost form with a known position, and the function whose
+	// body is being evaluated, for error reporting. Tail calls replace
+	// the function, as they replace its frame.
+	var at *Span
+	var call *Span
+
+	defer func() {
+		if err == nil {
+			return
+		}
+		if fn.IsInvokable() {
+			err = withFrame(annotate(err, at), fn, call)
+		} else {
+			err = annotate(err, at)
+		}
+	}()
 
 	for {
+		if err := x.session.step(); err != nil {
+			return NilExpression, err
+		}
+
+		if expr.span != nil {
+			at = expr.span
+		}
+
 		switch expr.tag {
 
 		case ExpNil:
@@ -315,7 +381,7 @@ func (x TcoInterpreter) Evaluate(env *Environment, expr Expression) (Expression,
 				if err != nil {
 					return NilExpression, err
 				}
-				env.Replace(expr, boundValue)
+				env.assign(expr.symbol, boundValue)
 				return boundValue, nil
 			}
 			return value, nil
@@ -323,9 +389,12 @@ func (x TcoInterpreter) Evaluate(env *Environment, expr Expression) (Expression,
 		case ExpQuote:
 			return *expr.quote, nil
 
-		case ExpInteger, ExpString, ExpFloat, ExpBool:
+		case ExpInteger, ExpString, ExpFloat, ExpBool, ExpKeyword:
 			return expr, nil
 
+		case ExpHashMap, ExpVector, ExpSet:
+			return evalCollection(x, env, expr)
+
 		case ExpList:
 			first := expr.Head()
 			rest := expr.Tail()
@@ -344,6 +413,24 @@ func (x TcoInterpreter) Evaluate(env *Environment, expr Expression) (Expression,
 					return expr, err
 				}
 
+			case "cond":
+				expr, err = evalCond(x, env, rest)
+				if err != nil {
+					return NilExpression, err
+				}
+
+			case "case":
+				expr, err = evalCase(x, env, rest)
+				if err != nil {
+					return NilExpression, err
+				}
+
+			case "when", "unless":
+				expr, err = evalWhen(x, env, rest, first.symbol == "when")
+				if err != nil {
+					return NilExpression, err
+				}
+
 			case "and":
 				return x.evalAnd(env, rest)
 
@@ -357,7 +444,11 @@ func (x TcoInterpreter) Evaluate(env *Environment, expr Expression) (Expression,
 				}
 
 			case "let":
-				env, expr, err = x.evalLet(env, rest.Head(), rest.Tail())
+				if rest.Head().IsSymbol() {
+					env, expr, err = evalNamedLet(x, env, rest)
+				} else {
+					env, expr, err = x.evalLet(env, rest.Head(), rest.Tail())
+				}
 				if err != nil {
 					return NilExpression, err
 				}
@@ -365,6 +456,36 @@ func (x TcoInterpreter) Evaluate(env *Environment, expr Expression) (Expression,
 			case "def":
 				return x.evalDef(env, rest.Head(), rest.Tail())
 
+			case "defmacro":
+				return evalDefmacro(env, rest)
+
+			case "quasiquote":
+				return evalQuasiquote(x, env, rest)
+
+			case "set!":
+				return evalSet(x, env, rest)
+
+			case "swap!":
+				return evalSwap(x, env, rest)
+
+			case "try":
+				return evalTry(x, env, rest)
+
+			case "ns":
+				return evalNs(env, rest)
+
+			case "require":
+				return evalRequire(x, env, expr)
+
+			case "load":
+				return evalLoad(x, env, expr)
+
+			case "macroexpand":
+				return evalMacroexpand(x, env, rest, false)
+
+			case "macroexpand-1":
+				return evalMacroexpand(x, env, rest, true)
+
 			case "defun":
 				name := rest.Head()
 				params := rest.Tail().Head()
@@ -377,12 +498,20 @@ func (x TcoInterpreter) Evaluate(env *Environment, expr Expression) (Expression,
 				return x.evalLambda(env, params, body)
 
 			default: // apply
-				fn, err := x.Evaluate(env, first)
+				op, err := x.Evaluate(env, first)
 				if err != nil {
 					return NilExpression, err
 				}
 
-				if !fn.IsInvokable() {
+				if op.IsMacro() {
+					expr, err = expandMacro(x, op, expr)
+					if err != nil {
+						return NilExpression, err
+					}
+					continue
+				}
+
+				if !op.IsInvokable() {
 					println("Not invokable.")
 				}
 				argv, err := x.evalList(env, rest.list)
@@ -390,27 +519,46 @@ func (x TcoInterpreter) Evaluate(env *Environment, expr Expression) (Expression,
 					return NilExpression, err
 				}
 
-				if fn.IsPrimitive() {
-					ret, err := fn.InvokePrimitive(argv)
-					return ret, err
+				if err := x.session.checkContext(); err != nil {
+					return NilExpression, err
 				}
 
-				ok, err := isValidArity(fn, rest.list)
+				if op.IsPrimitive() {
+					ret, err := op.InvokePrimitive(argv)
+					if err != nil {
+						return ret, err
+					}
+					return ret, x.session.checkSize(ret)
+				}
+
+				ok, err := isValidArity(op, rest.list)
 				if !ok {
 					return NilExpression, err
 				}
 
-				if fn.IsLambda() {
-					env = fn.functionEnv.ExtendEnvironment(*fn.functionParams, argv)
-					expr = *fn.functionBody
-				} else if fn.IsFunction() {
-					env = fn.functionEnv.ExtendEnvironment(*fn.functionParams, argv)
-					// env = env.ExtendEnvironment(*fn.functionParams, argv)
-					expr = *fn.functionBody
-				} else {
-					return nilExpr("unable to apply %v", fn)
+				if !op.IsLambda() && !op.IsFunction() {
+					return nilExpr("unable to apply %v", op)
 				}
+
+				// Tail calls take the place of the first call, so
+				// they don't go any deeper.
+				if !fn.IsInvokable() {
+					if err := x.session.enter(); err != nil {
+						return NilExpression, err
+					}
+					defer x.session.leave()
+				}
+
+				env, err = op.functionEnv.bindArgs(op, argv, x.Evaluate)
+				if err != nil {
+					return NilExpression, err
+				}
+				expr = *op.functionBody
+				fn, call = op, at
 			}
+
+		default:
+			return expr, nil
 		}
 
 	} // for
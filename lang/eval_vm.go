@@ -0,0 +1,562 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+// machine is the VM's mutable state: the operand stack shared by every
+// call in progress, and the code compiled for functions the VM didn't
+// make itself, such as those restored from a snapshot.
+type machine struct {
+	stack []Expression
+	procs map[*Expression]*proc // by function body
+}
+
+func newMachine() *machine {
+	return &machine{
+		stack: make([]Expression, 0, 256),
+		procs: make(map[*Expression]*proc),
+	}
+}
+
+func (m *machine) push(e Expression) {
+	m.stack = append(m.stack, e)
+}
+
+func (m *machine) pop() Expression {
+	e := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return e
+}
+
+func (m *machine) top() Expression {
+	return m.stack[len(m.stack)-1]
+}
+
+// popN removes the top n values, returning them in a new slice.
+func (m *machine) popN(n int) []Expression {
+	values := make([]Expression, n)
+	copy(values, m.stack[len(m.stack)-n:])
+	m.stack = m.stack[:len(m.stack)-n]
+	return values
+}
+
+// frame returns a new frame with a slot for each local declared so far.
+// Its names are a view of the layout's, so declaring more locals later
+// doesn't change them.
+func (l *frameLayout) frame(parent *frameType) *frameType {
+	n := len(l.names)
+	return &frameType{names: l.names[:n:n], slots: make([]Expression, n), parent: parent}
+}
+
+// reserve grows the frame for code compiled after it was made.
+func (frame *frameType) reserve(l *frameLayout) {
+	if n := len(l.names); n > len(frame.slots) {
+		frame.names = l.names[:n:n]
+		frame.slots = append(frame.slots, make([]Expression, n-len(frame.slots))...)
+	}
+}
+
+// local returns the local at a compiled address.
+func (frame *frameType) local(addr int) *Expression {
+	return frame.at(addr>>16, addr&maxSlot)
+}
+
+// close returns the function, closed over env.
+func (l *lambdaCode) close(env *Environment) Expression {
+	fn := l.template
+	fn.code = l.proc
+	fn.functionEnv = env.capture()
+	return fn
+}
+
+// bind binds the pattern's names to the parts of value in the slots
+// they were compiled to, which needn't be the last slots of the same
+// names in the frame.
+func (pc *patternCode) bind(frame *frameType, value Expression) error {
+	bound := newFrame(nil)
+	if err := bindPattern(bound, pc.pattern, value); err != nil {
+		return err
+	}
+	for i, name := range pc.names {
+		if j := bound.index(name); j >= 0 {
+			frame.slots[pc.slots[i]] = bound.slots[j]
+		}
+	}
+	return nil
+}
+
+// bind puts args, which must already have been checked with
+// isValidArity, in the frame of a call.
+func (p *proc) bind(frame *frameType, args []Expression) error {
+	params := p.params
+
+	for i, r := range params.required {
+		if r.pattern < 0 {
+			frame.slots[r.slot] = args[i]
+			continue
+		}
+		if err := p.patterns[r.pattern].bind(frame, args[i]); err != nil {
+			return err
+		}
+	}
+
+	rest := args[len(params.required):]
+	for _, slot := range params.optional {
+		if len(rest) == 0 {
+			break
+		}
+		frame.slots[slot] = rest[0]
+		rest = rest[1:]
+	}
+
+	if params.rest >= 0 {
+		frame.slots[params.rest] = NewListExpr(append([]Expression{}, rest...))
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// Calls
+//-----------------------------------------------------------------------------
+
+// procOf returns the code of a function, compiling it the first time
+// if the VM didn't make it.
+func (vm VMInterpreter) procOf(fn Expression) *proc {
+	if fn.code != nil {
+		return fn.code
+	}
+
+	p, found := vm.machine.procs[fn.functionBody]
+	if !found {
+		p = compileFunction(nil, fn)
+		vm.machine.procs[fn.functionBody] = p
+	}
+	return p
+}
+
+// prepare returns the code to call fn with, and the environment to run
+// it in: fn's, with a new frame holding the args.
+func (vm VMInterpreter) prepare(fn Expression, args []Expression) (*proc, *Environment, error) {
+	if ok, err := isValidArity(fn, args); !ok {
+		return nil, nil, err
+	}
+
+	p := vm.procOf(fn)
+	outer := fn.functionEnv
+	frame := p.frame.frame(outer.frame)
+	if err := p.bind(frame, args); err != nil {
+		return nil, nil, err
+	}
+	return p, &Environment{global: outer.global, frame: frame, ns: outer.ns}, nil
+}
+
+// expandAt expands a call to a macro that wasn't defined when the call
+// was compiled, and evaluates the expansion in the caller's frame. The
+// expansion's locals go in slots after those the frame already has,
+// leaving the caller's code, which other interpreters may be running,
+// as it was.
+func (vm VMInterpreter) expandAt(site *callSite, macro Expression, env *Environment) (Expression, error) {
+	form, err := expandMacro(vm, macro, site.form)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	n := len(env.frame.names)
+	layout := &frameLayout{names: env.frame.names[:n:n]}
+	s := &scope{parent: site.scope.parent, names: site.scope.names, frame: layout}
+
+	p := compileBlock(s, "", form, form.span)
+	env.frame.reserve(p.frame)
+	return vm.execute(NilExpression, p, env, 0, nil)
+}
+
+func (vm VMInterpreter) evalTry(t tryCode, env *Environment) (Expression, error) {
+	result, err := vm.execute(NilExpression, t.body, env, 0, nil)
+
+	if err != nil && !isCatchable(err) {
+		return NilExpression, err
+	}
+
+	if err != nil && t.handler != nil {
+		env.frame.slots[t.catch] = caught(err)
+		result, err = vm.execute(NilExpression, t.handler, env, 0, nil)
+	}
+
+	if t.cleanup != nil {
+		if _, cleanupErr := vm.execute(NilExpression, t.cleanup, env, 0, nil); cleanupErr != nil {
+			return NilExpression, cleanupErr
+		}
+	}
+
+	if err != nil {
+		return NilExpression, err
+	}
+	return result, nil
+}
+
+func (vm VMInterpreter) evalLoop(fn, lst Expression, indexed bool) error {
+	for i, e := range lst.list {
+		args := []Expression{e}
+		if indexed {
+			args = []Expression{NewIntExpr(int64(i)), e}
+		}
+		if _, err := vm.Apply(fn, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (vm VMInterpreter) evalSpecial(env *Environment, form Expression) (Expression, error) {
+	rest := form.Tail()
+	switch form.Head().symbol {
+	case "defmacro":
+		return evalDefmacro(env, rest)
+	case "ns":
+		return evalNs(env, rest)
+	case "require":
+		return evalRequire(vm, env, form)
+	default:
+		return evalLoad(vm, env, form)
+	}
+}
+
+func (vm VMInterpreter) lookup(env *Environment, name Expression) (Expression, error) {
+	found, value := env.Lookup(name.symbol)
+	if !found {
+		return nilExpr("value not found for '%v'", name.String())
+	}
+
+	if value.IsThunk() {
+		bound, err := vm.Evaluate(env, *value.functionBody)
+		if err != nil {
+			return NilExpression, err
+		}
+		env.assign(name.symbol, bound)
+		return bound, nil
+	}
+	return value, nil
+}
+
+func buildTemplate(t templateCode, parts []Expression) (Expression, error) {
+	list := make([]Expression, 0, len(parts))
+	for i, part := range parts {
+		if !t.splice[i] {
+			list = append(list, part)
+			continue
+		}
+		if !part.IsList() && !part.IsNil() {
+			return nilExpr("~@ expects a list, not '%v'", part.Type())
+		}
+		list = append(list, part.list...)
+	}
+	if t.form.tag != ExpList {
+		return withSpanOf(fromLiteral(t.form, list), t.form), nil
+	}
+	return withSpanOf(NewListExpr(list), t.form), nil
+}
+
+func truth(b bool) Expression {
+	if b {
+		return TrueExpression
+	}
+	return FalseExpression
+}
+
+//-----------------------------------------------------------------------------
+// EVAL
+//-----------------------------------------------------------------------------
+
+// Evaluate compiles an expression and runs it in a new frame of env.
+func (vm VMInterpreter) Evaluate(env *Environment, expr Expression) (Expression, error) {
+	return vm.run(env, compileForm(expr))
+}
+
+// runProgram runs each form of a program with the code kept for it.
+// Forms with macro calls are compiled each time, as the macros may
+// have changed.
+func (vm VMInterpreter) runProgram(env *Environment, prog *Program) (Expression, error) {
+	code := prog.code()
+	result := NilExpression
+	for i, form := range prog.forms {
+		expr, changed, err := expandAll(vm, env, form)
+		if err != nil {
+			return NilExpression, err
+		}
+
+		p := code[i]
+		if changed {
+			p = compileForm(expr)
+		}
+
+		if result, err = vm.run(env, p); err != nil {
+			return NilExpression, err
+		}
+	}
+	return result, nil
+}
+
+// run runs the code of a top level form in a new frame of env. A
+// namespace the form switches to is env's afterwards, as it would be
+// if the form were evaluated in env itself.
+func (vm VMInterpreter) run(env *Environment, p *proc) (Expression, error) {
+	scope := &Environment{global: env.global, frame: p.frame.frame(env.frame), ns: env.ns}
+	result, err := vm.execute(NilExpression, p, scope, 0, nil)
+	env.ns = scope.ns
+	return result, err
+}
+
+// execute runs code in env, whose innermost frame holds its locals,
+// returning the value it returns. fn is the function the code is the
+// body of, if any, and call where it was called, for error reporting.
+// Tail calls replace both, as they replace the code and environment.
+func (vm VMInterpreter) execute(fn Expression, p *proc, env *Environment, argc int, call *Span) (result Expression, err error) {
+	m := vm.machine
+	base := len(m.stack)
+	pc := 0
+
+	defer func() {
+		m.stack = m.stack[:base]
+		if err == nil {
+			return
+		}
+		at := p.spanAt(pc - 1)
+		if fn.IsInvokable() {
+			err = withFrame(annotate(err, at), fn, call)
+		} else {
+			err = annotate(err, at)
+		}
+	}()
+
+	if err := vm.session.step(); err != nil {
+		return NilExpression, err
+	}
+
+	for {
+		in := p.code[pc]
+		pc++
+		a := in.arg()
+
+		switch in.op() {
+
+		case opConst:
+			m.push(p.consts[a])
+
+		case opLocal:
+			m.push(*env.frame.local(a))
+
+		case opSetLocal:
+			*env.frame.local(a) = m.top()
+
+		case opGlobal:
+			value, err := vm.lookup(env, p.consts[a])
+			if err != nil {
+				return NilExpression, err
+			}
+			m.push(value)
+
+		case opSetGlobal:
+			name := p.consts[a].symbol
+			if !env.assign(name, m.top()) {
+				return nilExpr("%v «-- '%v' is not defined", setSig, name)
+			}
+
+		case opDefine:
+			env.Set(p.consts[a], m.top())
+
+		case opPop:
+			m.pop()
+
+		case opJump:
+			pc = a
+
+		case opJumpIf:
+			if m.pop().IsTruthy() {
+				pc = a
+			}
+
+		case opJumpUnless:
+			if !m.pop().IsTruthy() {
+				pc = a
+			}
+
+		case opAnd:
+			if !m.top().IsTruthy() {
+				pc = a
+			} else {
+				m.pop()
+			}
+
+		case opOr:
+			if m.top().IsTruthy() {
+				pc = a
+			} else {
+				m.pop()
+			}
+
+		case opSupplied:
+			m.push(truth(argc > a))
+
+		case opMatch:
+			m.push(truth(caseMatches(p.consts[a], m.top())))
+
+		case opMacro:
+			if op := m.top(); op.IsMacro() {
+				m.pop()
+				site := p.sites[a]
+				value, err := vm.expandAt(site, op, env)
+				if err != nil {
+					return NilExpression, err
+				}
+				m.push(value)
+				pc = site.end
+			}
+
+		case opCall, opTailCall:
+			n := p.sites[a].argc
+			args := m.popN(n)
+			op := m.pop()
+
+			if err := vm.session.checkContext(); err != nil {
+				return NilExpression, err
+			}
+
+			if op.IsPrimitive() {
+				ret, err := op.InvokePrimitive(args)
+				if err != nil {
+					return ret, err
+				}
+				if err := vm.session.checkSize(ret); err != nil {
+					return NilExpression, err
+				}
+				m.push(ret)
+				continue
+			}
+
+			next, nextEnv, err := vm.prepare(op, args)
+			if err != nil {
+				return NilExpression, err
+			}
+
+			at := p.spanAt(pc - 1)
+			if in.op() == opCall {
+				if err := vm.session.enter(); err != nil {
+					return NilExpression, err
+				}
+				value, err := vm.execute(op, next, nextEnv, n, at)
+				vm.session.leave()
+				if err != nil {
+					return NilExpression, err
+				}
+				m.push(value)
+				continue
+			}
+
+			if err := vm.session.step(); err != nil {
+				return NilExpression, err
+			}
+
+			// Tail calls take the place of the first call, so
+			// they don't go any deeper.
+			if !fn.IsInvokable() {
+				if err := vm.session.enter(); err != nil {
+					return NilExpression, err
+				}
+				defer vm.session.leave()
+			}
+			fn, call = op, at
+			p, env, argc = next, nextEnv, n
+			pc = 0
+			m.stack = m.stack[:base]
+
+		case opReturn:
+			return m.pop(), nil
+
+		case opClosure:
+			m.push(p.lambdas[a].close(env))
+
+		case opBind:
+			if err := p.patterns[a].bind(env.frame, m.pop()); err != nil {
+				return NilExpression, err
+			}
+
+		case opCollection:
+			lit := p.literals[a]
+			m.push(fromLiteral(lit.form, m.popN(lit.size)))
+
+		case opTemplate:
+			t := p.templates[a]
+			list, err := buildTemplate(t, m.popN(len(t.splice)))
+			if err != nil {
+				return NilExpression, err
+			}
+			m.push(list)
+
+		case opQuote:
+			m.push(NewExpr(ExpQuote, m.pop()))
+
+		case opQualify:
+			m.push(env.qualify(p.consts[a]))
+
+		case opTry:
+			value, err := vm.evalTry(p.tries[a], env)
+			if err != nil {
+				return NilExpression, err
+			}
+			m.push(value)
+
+		case opLoop:
+			lst := m.pop()
+			if err := vm.evalLoop(m.pop(), lst, a == 1); err != nil {
+				return NilExpression, err
+			}
+			m.push(NilExpression)
+
+		case opSwap:
+			value, err := swapAtom(vm, m.popN(a))
+			if err != nil {
+				return NilExpression, err
+			}
+			m.push(value)
+
+		case opMacroexpand:
+			var form Expression
+			var err error
+			if a == 1 {
+				form, _, err = macroexpand1(vm, env, m.pop())
+			} else {
+				form, _, err = macroexpand(vm, env, m.pop())
+			}
+			if err != nil {
+				return NilExpression, err
+			}
+			m.push(form)
+
+		case opSpecial:
+			value, err := vm.evalSpecial(env, p.consts[a])
+			if err != nil {
+				return NilExpression, err
+			}
+			m.push(value)
+
+		case opFail:
+			return NilExpression, p.errs[a]
+
+		default:
+			return nilExpr("unknown instruction %v", in.op())
+		}
+	}
+}
@@ -17,11 +17,12 @@
 package lang
 
 import (
+	"errors"
 	"fmt"
-	"hash/fnv"
 	"log"
+	"math"
 	"os"
-	"strings"
+	"sync/atomic"
 )
 
 // ExpressionType is the type of expression
@@ -43,6 +44,12 @@ const (
 	ExpFile    // represents a file-handle
 	ExpHashMap // 12
 	ExpThunk   // 13
+	ExpMacro   // 14
+	ExpError   // 15
+	ExpAtom    // 16
+	ExpKeyword // 17
+	ExpVector  // 18
+	ExpSet     // 19
 )
 
 // ExprTypeName returns the type name of an expression type
@@ -62,6 +69,12 @@ func ExprTypeName(v ExpressionType) string {
 		ExpFile:      "file",
 		ExpHashMap:   "hash-map",
 		ExpThunk:     "thunk",
+		ExpMacro:     "macro",
+		ExpError:     "error",
+		ExpAtom:      "atom",
+		ExpKeyword:   "keyword",
+		ExpVector:    "vector",
+		ExpSet:       "set",
 	}
 
 	value, ok := names[v]
@@ -79,7 +92,6 @@ func (e Expression) Type() string {
 // Expression represents a computation
 type Expression struct {
 	tag            ExpressionType
-	hash           uint32
 	string         string
 	integer        int64
 	float          float64
@@ -87,30 +99,31 @@ type Expression struct {
 	bool           bool
 	list           []Expression
 	quote          *Expression
-	primitive      primitiveFunc
+	primitive      PrimitiveFunc
 	functionName   string
+	doc            string
 	functionParams *Expression
+	signature      *signature
 	functionBody   *Expression
 	functionEnv    *Environment
 	file           *fileData
 	hashMap        *HakiHashMap
+	errorVal       *errorData
+	atom           *atomRef
 	thunkValue     *Expression
+	buffer         *listBuffer // the array list is a window onto, if shared
+	cached         *hashCell   // a collection's hash, once it's known
+	code           *proc       // compiled body, for functions made by the VM
+	span           *Span       // where the expression was read, if from source
 }
 
-func hashIt(values ...interface{}) uint32 {
-	hash := fnv.New32()
-	for _, v := range values {
-		fmt.Fprint(hash, v)
-	}
-	return hash.Sum32()
-}
+var genSymCounter int64
 
-var genSymCounter = 0
-
-// GenSym produces a unique symbol name per runtime.
+// GenSym produces a unique symbol name per runtime. It's safe to call
+// from concurrently running interpreters.
 func GenSym(prefix string) Expression {
-	genSymCounter = genSymCounter + 1
-	return NewExpr(ExpSymbol, fmt.Sprintf("%v%v", prefix, genSymCounter))
+	n := atomic.AddInt64(&genSymCounter, 1)
+	return NewExpr(ExpSymbol, fmt.Sprintf("%v%v", prefix, n))
 }
 
 // WrapImplicitDo wraps expressions in a do expression.
@@ -159,6 +172,11 @@ func NewIntExpr(v int64) Expression {
 	return NewExpr(ExpInteger, v)
 }
 
+// NewFloatExpr returns an expression representing a float
+func NewFloatExpr(v float64) Expression {
+	return NewExpr(ExpFloat, v)
+}
+
 // NewBoolExpr returns an expression representing a boolean value
 func NewBoolExpr(b bool) Expression {
 	return NewExpr(ExpBool, b)
@@ -168,13 +186,13 @@ func NewBoolExpr(b bool) Expression {
 func NewFunctionExpr(env *Environment, name Expression, params Expression, body Expression) Expression {
 	p := params
 	b := WrapImplicitDo(body.list)
-	e := env.Clone()
+	e := env.capture()
 
 	return Expression{
 		tag:            ExpFunction,
-		hash:           hashIt(ExpFunction, name, p.hash, b.hash, e),
 		functionName:   name.symbol,
 		functionParams: &p,
+		signature:      newSignature(p),
 		functionBody:   &b,
 		functionEnv:    e,
 	}
@@ -184,12 +202,28 @@ func NewFunctionExpr(env *Environment, name Expression, params Expression, body
 func NewLambdaExpr(env *Environment, name, params, body Expression) Expression {
 	p := params
 	b := body
-	e := env.Clone()
+	e := env.capture()
 	return Expression{
 		tag:            ExpLambda,
-		hash:           hashIt(ExpLambda, name, p.hash, b.hash, e),
 		functionName:   name.symbol,
 		functionParams: &p,
+		signature:      newSignature(p),
+		functionBody:   &b,
+		functionEnv:    e,
+	}
+}
+
+// NewMacroExpr produces a new macro expression, a function from forms
+// to a form.
+func NewMacroExpr(env *Environment, name, params, body Expression) Expression {
+	p := params
+	b := WrapImplicitDo(body.list)
+	e := env.capture()
+	return Expression{
+		tag:            ExpMacro,
+		functionName:   name.symbol,
+		functionParams: &p,
+		signature:      newSignature(p),
 		functionBody:   &b,
 		functionEnv:    e,
 	}
@@ -201,7 +235,6 @@ func NewThunkExpr(body Expression) Expression {
 	b := body
 	return Expression{
 		tag:          ExpThunk,
-		hash:         hashIt(ExpThunk, n.hash, b.hash),
 		functionName: n.symbol,
 		functionBody: &b,
 		thunkValue:   nil,
@@ -226,10 +259,10 @@ func NewExpr(tag ExpressionType, value interface{}) Expression {
 
 	// Simple types here.
 
-	e := Expression{tag: tag, hash: hashIt(tag, value)}
+	e := Expression{tag: tag}
 	switch tag {
 	case ExpPrimitive:
-		e.primitive = value.(primitiveFunc)
+		e.primitive = value.(PrimitiveFunc)
 	case ExpList:
 		e.list = value.([]Expression)
 	case ExpString:
@@ -238,7 +271,7 @@ func NewExpr(tag ExpressionType, value interface{}) Expression {
 		e.integer = value.(int64)
 	case ExpFloat:
 		e.float = value.(float64)
-	case ExpSymbol:
+	case ExpSymbol, ExpKeyword:
 		e.symbol = value.(string)
 	case ExpBool:
 		e.bool = value.(bool)
@@ -309,7 +342,7 @@ func (e Expression) Tail() Expression {
 	if len(e.list) == 0 {
 		return e
 	}
-	return Expression{tag: ExpList, list: e.list[1:]}
+	return tailOf(e)
 }
 
 func (e Expression) String() string {
@@ -317,23 +350,19 @@ func (e Expression) String() string {
 	case ExpPrimitive:
 		return fmt.Sprintf("builtin::%v", e.primitive)
 	case ExpList:
-		elems := make([]string, 0)
-		for _, e := range e.list {
-			elems = append(elems, e.String())
-		}
-		return fmt.Sprintf("(%v)", strings.Join(elems, " "))
+		return "(" + printList(e.list) + ")"
 	case ExpString:
-		return "\"" + e.string + "\""
+		return printString(e.string)
 	case ExpInteger:
 		return fmt.Sprintf("%d", e.integer)
 	case ExpFloat:
-		return fmt.Sprintf("%v", e.float)
+		return printFloat(e.float)
 	case ExpSymbol:
 		return e.symbol
 	case ExpBool:
 		return fmt.Sprintf("%v", e.bool)
 	case ExpQuote:
-		return e.quote.String()
+		return "'" + e.quote.String()
 	case ExpNil:
 		return "nil"
 	case ExpLambda:
@@ -342,6 +371,8 @@ func (e Expression) String() string {
 		return fmt.Sprintf("fn<%v %v>", e.functionName, e.functionParams)
 	case ExpThunk:
 		return fmt.Sprintf("thunk<%v %v>", e.functionName, e.functionBody)
+	case ExpMacro:
+		return fmt.Sprintf("macro<%v %v>", e.functionName, e.functionParams)
 	case ExpFile:
 		status := " (closed)"
 		if e.file.isOpen {
@@ -350,6 +381,16 @@ func (e Expression) String() string {
 		return "file://" + e.file.path + status
 	case ExpHashMap:
 		return e.hashMap.String()
+	case ExpKeyword:
+		return e.symbol
+	case ExpVector:
+		return "[" + printList(e.list) + "]"
+	case ExpSet:
+		return "#{" + printList(e.hashMap.sortedKeys()) + "}"
+	case ExpError:
+		return fmt.Sprintf("error<%v>", e.errorVal.message)
+	case ExpAtom:
+		return fmt.Sprintf("atom<%v>", e.atom.get())
 	default:
 		return fmt.Sprintf("unknown→%#v", e)
 	}
@@ -420,11 +461,42 @@ func (e Expression) IsLambda() bool {
 	return e.tag == ExpLambda
 }
 
+// IsMacro returns true if the expression represents a macro
+func (e Expression) IsMacro() bool {
+	return e.tag == ExpMacro
+}
+
 // IsThunk is true of expression is a thunk
 func (e Expression) IsThunk() bool {
 	return e.tag == ExpThunk
 }
 
+// IsError returns true if expr is an error value
+func (e Expression) IsError() bool {
+	return e.tag == ExpError
+}
+
+// IsKeyword returns true if expr is a :keyword
+func (e Expression) IsKeyword() bool {
+	return e.tag == ExpKeyword
+}
+
+// IsVector returns true if expr is a [vector]
+func (e Expression) IsVector() bool {
+	return e.tag == ExpVector
+}
+
+// IsSet returns true if expr is a #{set}
+func (e Expression) IsSet() bool {
+	return e.tag == ExpSet
+}
+
+// IsAtomRef returns true if expr is a reference made with (atom val),
+// rather than an atom in the sense of IsAtom.
+func (e Expression) IsAtomRef() bool {
+	return e.tag == ExpAtom
+}
+
 // IsQuote returns true if expr is a quote
 func (e Expression) IsQuote() bool {
 	return e.tag == ExpQuote
@@ -453,9 +525,69 @@ func (e Expression) InvokePrimitive(params []Expression) (Expression, error) {
 	return e.primitive(params)
 }
 
-// Equals returns true of the values of e1 and e2 match
+// Equals returns true if e and e2 are the same value. Collections are
+// compared by their sizes, then by their hashes, and only those whose
+// hashes match are compared structurally, so values that happen to
+// share a hash aren't equal. Numbers of different types are never
+// equal: (= 1 1.0) is false, though (== 1 1.0) is true. Floats are
+// equal if they're the same float, so NaN equals NaN, but -0.0
+// doesn't equal 0.0. Functions are equal if they have the same code,
+// closed over the same frames. Atoms are only equal to themselves.
 func (e Expression) Equals(e2 Expression) bool {
-	return e.hash == e2.hash
+	if e.tag != e2.tag {
+		return false
+	}
+
+	switch e.tag {
+	case ExpBool:
+		return e.bool == e2.bool
+	case ExpInteger:
+		return e.integer == e2.integer
+	case ExpFloat:
+		return math.Float64bits(e.float) == math.Float64bits(e2.float) ||
+			math.IsNaN(e.float) && math.IsNaN(e2.float)
+	case ExpString:
+		return e.string == e2.string
+	case ExpSymbol, ExpKeyword:
+		return e.symbol == e2.symbol
+	case ExpQuote:
+		return e.quote.Equals(*e2.quote)
+	case ExpList, ExpVector:
+		return len(e.list) == len(e2.list) && e.hashCode() == e2.hashCode() &&
+			sameElems(e.list, e2.list)
+	case ExpHashMap, ExpSet:
+		return e.hashMap.size() == e2.hashMap.size() && e.hashCode() == e2.hashCode() &&
+			e.hashMap.sameEntries(e2.hashMap)
+	case ExpFunction, ExpLambda, ExpMacro:
+		return e.functionName == e2.functionName && e.code == e2.code &&
+			e.functionEnv.frame == e2.functionEnv.frame &&
+			e.functionParams.Equals(*e2.functionParams) &&
+			e.functionBody.Equals(*e2.functionBody)
+	case ExpPrimitive:
+		return e.functionName == e2.functionName
+	case ExpThunk:
+		return e.functionBody == e2.functionBody
+	case ExpFile:
+		return e.file.path == e2.file.path
+	case ExpError:
+		return e.errorVal.message == e2.errorVal.message &&
+			e.errorVal.data.Equals(e2.errorVal.data)
+	case ExpAtom:
+		return e.atom == e2.atom
+	}
+	return true
+}
+
+func sameElems(xs, ys []Expression) bool {
+	if len(xs) != len(ys) {
+		return false
+	}
+	for i := range xs {
+		if !xs[i].Equals(ys[i]) {
+			return false
+		}
+	}
+	return true
 }
 
 // IntValue of the expression
@@ -494,8 +626,24 @@ func (e Expression) Value() interface{} {
 		return fmt.Sprintf("lambda<%v|%v %v>", e.functionName, e.functionParams, e.functionBody)
 	case ExpFunction:
 		return fmt.Sprintf("fn<%v %v>", e.functionName, e.functionParams)
+	case ExpMacro:
+		return fmt.Sprintf("macro<%v %v>", e.functionName, e.functionParams)
 	case ExpHashMap:
 		return e.hashMap
+	case ExpError:
+		return errors.New(e.errorVal.message)
+	case ExpAtom:
+		return e.atom.get().Value()
+	case ExpKeyword:
+		return e.symbol
+	case ExpVector:
+		elems := make([]interface{}, 0)
+		for _, e := range e.list {
+			elems = append(elems, e.Value())
+		}
+		return elems
+	case ExpSet:
+		return e.hashMap
 	default:
 		return fmt.Sprintf("unknown→%#v", e)
 	}
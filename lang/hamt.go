@@ -0,0 +1,239 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import "math/bits"
+
+// Hash-maps and sets are hash array mapped tries: each node holds up
+// to 32 slots, picked by the next 5 bits of a key's hash, and stores
+// only the slots in use, in order, with a bitmap saying which they
+// are. Nodes are never changed once made. Adding or removing a key
+// copies the nodes on the path to it and shares the rest, so a new map
+// costs O(log n) and the map it was made from is untouched. Keys
+// whose hashes are the same, but which aren't equal, share a slot, in
+// a chain of entries.
+
+const (
+	hamtBits = 5
+	hamtMask = 1<<hamtBits - 1
+)
+
+type hamtNode struct {
+	bitmap uint32
+	slots  []hamtSlot
+}
+
+// hamtSlot is a chain of entries with the same hash, or a node for the
+// entries whose hashes share the bits that led to the slot.
+type hamtSlot struct {
+	entry *hamtEntry
+	node  *hamtNode
+}
+
+type hamtEntry struct {
+	key   Expression
+	value Expression
+	hash  uint32     // the key's hash
+	next  *hamtEntry // another key with the same hash
+}
+
+// position returns the bit for hash at shift, and the index its slot
+// has, or would have, in the node.
+func (n *hamtNode) position(hash uint32, shift uint) (uint32, int) {
+	bit := uint32(1) << (hash >> shift & hamtMask)
+	return bit, bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+// find returns the entry for key, whose hash is hash, or nil.
+func (n *hamtNode) find(key Expression, hash uint32) *hamtEntry {
+	for shift := uint(0); n != nil; shift += hamtBits {
+		bit, i := n.position(hash, shift)
+		if n.bitmap&bit == 0 {
+			return nil
+		}
+		slot := n.slots[i]
+		if slot.node == nil {
+			if slot.entry.hash == hash {
+				return slot.entry.find(key)
+			}
+			return nil
+		}
+		n = slot.node
+	}
+	return nil
+}
+
+// with returns a node with entry e, and the entry it replaced, if any.
+// n may be nil, for an empty node.
+func (n *hamtNode) with(e *hamtEntry, shift uint) (*hamtNode, *hamtEntry) {
+	hash := e.hash
+	if n == nil {
+		n = &hamtNode{}
+	}
+
+	bit, i := n.position(hash, shift)
+	if n.bitmap&bit == 0 {
+		return n.insert(bit, i, hamtSlot{entry: e}), nil
+	}
+
+	slot := n.slots[i]
+	switch {
+
+	case slot.node != nil:
+		node, old := slot.node.with(e, shift+hamtBits)
+		return n.replace(i, hamtSlot{node: node}), old
+
+	case slot.entry.hash == hash:
+		chain, old := slot.entry.with(e)
+		return n.replace(i, hamtSlot{entry: chain}), old
+
+	default:
+		node, _ := (*hamtNode)(nil).with(slot.entry, shift+hamtBits)
+		node, _ = node.with(e, shift+hamtBits)
+		return n.replace(i, hamtSlot{node: node}), nil
+	}
+}
+
+// without returns a node without the entry for key, whose hash is
+// hash, which is nil if it's empty, and the entry it removed, if any.
+func (n *hamtNode) without(key Expression, hash uint32, shift uint) (*hamtNode, *hamtEntry) {
+	if n == nil {
+		return nil, nil
+	}
+
+	bit, i := n.position(hash, shift)
+	if n.bitmap&bit == 0 {
+		return n, nil
+	}
+
+	slot := n.slots[i]
+	if slot.node == nil {
+		if slot.entry.hash != hash {
+			return n, nil
+		}
+		chain, old := slot.entry.without(key)
+		switch {
+		case old == nil:
+			return n, nil
+		case chain == nil:
+			return n.remove(bit, i), old
+		default:
+			return n.replace(i, hamtSlot{entry: chain}), old
+		}
+	}
+
+	node, old := slot.node.without(key, hash, shift+hamtBits)
+	switch {
+	case old == nil:
+		return n, nil
+	case node == nil:
+		return n.remove(bit, i), old
+	case len(node.slots) == 1 && node.slots[0].node == nil:
+		return n.replace(i, node.slots[0]), old
+	default:
+		return n.replace(i, hamtSlot{node: node}), old
+	}
+}
+
+func (n *hamtNode) insert(bit uint32, i int, slot hamtSlot) *hamtNode {
+	slots := make([]hamtSlot, len(n.slots)+1)
+	copy(slots, n.slots[:i])
+	slots[i] = slot
+	copy(slots[i+1:], n.slots[i:])
+	return &hamtNode{bitmap: n.bitmap | bit, slots: slots}
+}
+
+func (n *hamtNode) replace(i int, slot hamtSlot) *hamtNode {
+	slots := append([]hamtSlot{}, n.slots...)
+	slots[i] = slot
+	return &hamtNode{bitmap: n.bitmap, slots: slots}
+}
+
+func (n *hamtNode) remove(bit uint32, i int) *hamtNode {
+	if len(n.slots) == 1 {
+		return nil
+	}
+	slots := make([]hamtSlot, 0, len(n.slots)-1)
+	slots = append(slots, n.slots[:i]...)
+	slots = append(slots, n.slots[i+1:]...)
+	return &hamtNode{bitmap: n.bitmap &^ bit, slots: slots}
+}
+
+// each calls fn with every entry, in order of their hashes' low bits.
+func (n *hamtNode) each(fn func(e *hamtEntry)) {
+	if n == nil {
+		return
+	}
+	for _, slot := range n.slots {
+		if slot.node != nil {
+			slot.node.each(fn)
+			continue
+		}
+		for e := slot.entry; e != nil; e = e.next {
+			fn(e)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Chains
+//-----------------------------------------------------------------------------
+
+// find returns the entry in the chain whose key equals key, or nil.
+func (e *hamtEntry) find(key Expression) *hamtEntry {
+	for ; e != nil; e = e.next {
+		if e.key.Equals(key) {
+			return e
+		}
+	}
+	return nil
+}
+
+// with returns the chain with entry added, in place of the entry with
+// an equal key, which it also returns, if there is one.
+func (e *hamtEntry) with(entry *hamtEntry) (*hamtEntry, *hamtEntry) {
+	if e == nil {
+		return entry, nil
+	}
+	if e.key.Equals(entry.key) {
+		head := *entry
+		head.next = e.next
+		return &head, e
+	}
+	next, old := e.next.with(entry)
+	head := *e
+	head.next = next
+	return &head, old
+}
+
+// without returns the chain without the entry whose key equals key,
+// and that entry, if there is one.
+func (e *hamtEntry) without(key Expression) (*hamtEntry, *hamtEntry) {
+	if e == nil {
+		return nil, nil
+	}
+	if e.key.Equals(key) {
+		return e.next, e
+	}
+	next, old := e.next.without(key)
+	if old == nil {
+		return e, nil
+	}
+	head := *e
+	head.next = next
+	return &head, old
+}
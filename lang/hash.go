@@ -0,0 +1,155 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Hashes are only needed to compare values and to use them as keys,
+// so they're computed when they're first asked for, not when a value
+// is made. Atoms, numbers, strings and the like are cheap to hash, and
+// are hashed each time. Lists, vectors, sets and hash-maps are hashed
+// once, and keep their hash in a cell shared by every copy of their
+// expression. Equal values always have the same hash, but values with
+// the same hash needn't be equal.
+
+// hashCell holds a collection's hash once it's known. Interpreters
+// running at the same time may share a collection, so it's filled in
+// atomically.
+type hashCell struct {
+	bits uint64 // the hash, with hashKnown set once it's computed
+}
+
+const hashKnown = 1 << 32
+
+func newHashCell() *hashCell {
+	return &hashCell{}
+}
+
+// get returns the hash in the cell, computing it first if need be. A
+// nil cell computes it every time.
+func (c *hashCell) get(compute func() uint32) uint32 {
+	if c == nil {
+		return compute()
+	}
+	if bits := atomic.LoadUint64(&c.bits); bits&hashKnown != 0 {
+		return uint32(bits)
+	}
+	h := compute()
+	atomic.StoreUint64(&c.bits, hashKnown|uint64(h))
+	return h
+}
+
+// hashCode returns the hash of e.
+func (e Expression) hashCode() uint32 {
+	seed := tagSeed(e.tag)
+
+	switch e.tag {
+	case ExpBool:
+		if e.bool {
+			return mixHash(seed, 1)
+		}
+		return mixHash(seed, 0)
+	case ExpInteger:
+		return mixHash(seed, hashUint64(uint64(e.integer)))
+	case ExpFloat:
+		bits := math.Float64bits(e.float)
+		if math.IsNaN(e.float) {
+			bits = math.Float64bits(math.NaN())
+		}
+		return mixHash(seed, hashUint64(bits))
+	case ExpString:
+		return hashString(seed, e.string)
+	case ExpSymbol, ExpKeyword:
+		return hashString(seed, e.symbol)
+	case ExpQuote:
+		return mixHash(seed, e.quote.hashCode())
+	case ExpList, ExpVector:
+		return e.cached.get(func() uint32 {
+			return seqHash(seed, e.list)
+		})
+	case ExpHashMap, ExpSet:
+		return e.cached.get(func() uint32 {
+			return e.hashMap.hashCode(seed)
+		})
+	case ExpFunction, ExpLambda, ExpMacro, ExpPrimitive, ExpThunk:
+		return hashString(seed, e.functionName)
+	case ExpFile:
+		return hashString(seed, e.file.path)
+	case ExpError:
+		return mixHash(hashString(seed, e.errorVal.message), e.errorVal.data.hashCode())
+	case ExpAtom:
+		return mixHash(seed, hashUint64(e.atom.id))
+	}
+	return seed
+}
+
+// seqHash returns the hash of a list or vector of elems.
+func seqHash(seed uint32, elems []Expression) uint32 {
+	h := seed
+	for _, e := range elems {
+		h = h*fnvPrime + e.hashCode()
+	}
+	return finishHash(h)
+}
+
+const (
+	fnvOffset = 2166136261
+	fnvPrime  = 16777619
+)
+
+func tagSeed(tag ExpressionType) uint32 {
+	return (fnvOffset ^ uint32(tag)) * fnvPrime
+}
+
+// hashString hashes s with FNV-1a, starting from seed.
+func hashString(seed uint32, s string) uint32 {
+	h := seed
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= fnvPrime
+	}
+	return h
+}
+
+// mixHash combines hash h with v.
+func mixHash(h, v uint32) uint32 {
+	return finishHash((h ^ v) * fnvPrime)
+}
+
+// hashUint64 spreads the bits of x over its hash.
+func hashUint64(x uint64) uint32 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return uint32(x) ^ uint32(x>>32)
+}
+
+// finishHash spreads the bits of h, so the low bits, which pick a
+// key's slot in a hash-map, depend on all of them.
+func finishHash(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
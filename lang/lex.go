@@ -19,6 +19,7 @@ package lang
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 type tokenType int
@@ -27,28 +28,46 @@ type tokenType int
 const (
 	AOpenParen tokenType = iota
 	ACloseParen
+	AOpenBrace
+	ACloseBrace
+	AOpenBracket
+	ACloseBracket
+	AOpenSet
 	ASymbol
 	AString
 	AInteger
 	AFloat
 	AQuote
+	AQuasiQuote
+	AUnquote
+	AUnquoteSplicing
 )
 
 // Token is the smallest unit of meaning for the little language
 type Token struct {
 	kind  tokenType
 	value string
+	start mark
+	end   mark
 }
 
 func (t Token) String() string {
 	d := map[tokenType]string{
-		AOpenParen:  "open-paren",
-		ACloseParen: "close-paren",
-		ASymbol:     "symbol",
-		AString:     "string",
-		AInteger:    "integer",
-		AFloat:      "float",
-		AQuote:      "quote",
+		AOpenParen:       "open-paren",
+		ACloseParen:      "close-paren",
+		AOpenBrace:       "open-brace",
+		ACloseBrace:      "close-brace",
+		AOpenBracket:     "open-bracket",
+		ACloseBracket:    "close-bracket",
+		AOpenSet:         "open-set",
+		ASymbol:          "symbol",
+		AString:          "string",
+		AInteger:         "integer",
+		AFloat:           "float",
+		AQuote:           "quote",
+		AQuasiQuote:      "quasiquote",
+		AUnquote:         "unquote",
+		AUnquoteSplicing: "unquote-splicing",
 	}
 	return fmt.Sprintf("<#%v:[%+v]>", d[t.kind], t.value)
 }
@@ -58,14 +77,34 @@ type Tokens struct {
 	Tokens []Token
 	word   []rune
 	form   string
-	kind   tokenType
+	at     mark // position of the rune being read
+	prev   mark // position of the rune before it
+	start  mark // where the current word started
 }
 
 func (ts *Tokens) pushChar(c rune) {
+	if len(ts.word) == 0 {
+		ts.start = ts.at
+	}
 	ts.word = append(ts.word, c)
 }
 
+// advance moves the read position past c.
+func (ts *Tokens) advance(c rune) {
+	ts.prev = ts.at
+	if c == '\n' {
+		ts.at.line++
+		ts.at.col = 1
+	} else {
+		ts.at.col++
+	}
+}
+
 func (ts *Tokens) pushWord() {
+	ts.pushWordEnding(ts.prev)
+}
+
+func (ts *Tokens) pushWordEnding(end mark) {
 	isFloat := func(s string) bool {
 		_, err := strconv.ParseFloat(s, 64)
 		return err == nil
@@ -77,30 +116,71 @@ func (ts *Tokens) pushWord() {
 	}
 	if len(ts.word) > 0 {
 		w := string(ts.word)
-		k := ts.kind
-		if k == AString {
-			// Don't convert if actual string.
-		} else if isInteger(w) {
+		k := ASymbol
+		if isInteger(w) {
 			k = AInteger
 		} else if isFloat(w) {
 			k = AFloat
 		}
-		ts.Tokens = append(ts.Tokens, Token{k, w})
+		ts.Tokens = append(ts.Tokens, Token{k, w, ts.start, end})
 		ts.word = make([]rune, 0)
-		ts.kind = ASymbol
 	}
 }
 
-func (ts *Tokens) setKind(kind tokenType) {
-	ts.kind = kind
+// pushString reads the string literal starting at runes[i], returning
+// the index of its last rune.
+func (ts *Tokens) pushString(runes []rune, i int) (int, error) {
+	start := ts.at
+	quote := openQuote(runes[i:])
+	n := closeQuote(runes[i+len(quote):], quote)
+	if n < 0 {
+		return i, &Error{Message: "unterminated string", Span: *newSpan(start, start)}
+	}
+
+	literal := runes[i : i+len(quote)+n]
+	content := literal[len(quote) : len(literal)-len(quote)]
+
+	value := string(content)
+	if quote == plainQuote {
+		var err error
+		if value, err = unescape(content); err != nil {
+			return i, &Error{Message: err.Error(), Span: *newSpan(start, start)}
+		}
+	}
+
+	for _, c := range literal[:len(literal)-1] {
+		ts.advance(c)
+	}
+	ts.Tokens = append(ts.Tokens, Token{AString, value, start, ts.at})
+	return i + len(literal) - 1, nil
 }
 
 func (ts *Tokens) pushToken(kind tokenType, value string) {
-	ts.Tokens = append(ts.Tokens, Token{kind, value})
+	ts.Tokens = append(ts.Tokens, Token{kind, value, ts.at, ts.at})
+}
+
+// follows returns true if the last token was of the given kind, and
+// ended at the previous rune.
+func (ts *Tokens) follows(kind tokenType) bool {
+	if len(ts.Tokens) == 0 {
+		return false
+	}
+	last := ts.Tokens[len(ts.Tokens)-1]
+	return last.kind == kind && last.end == ts.prev
+}
+
+// spliceUnquote turns a ~ just read into ~@.
+func (ts *Tokens) spliceUnquote() {
+	last := &ts.Tokens[len(ts.Tokens)-1]
+	last.kind = AUnquoteSplicing
+	last.value = "~@"
+	last.end = ts.at
 }
 
-func (ts *Tokens) inString() bool {
-	return ts.kind == AString
+// openSet turns a # just read into the start of a #{ set literal.
+func (ts *Tokens) openSet() {
+	ts.Tokens = append(ts.Tokens, Token{AOpenSet, "#{", ts.start, ts.at})
+	ts.word = make([]rune, 0)
 }
 
 func (ts *Tokens) emptyWord() bool {
@@ -109,45 +189,90 @@ func (ts *Tokens) emptyWord() bool {
 
 // Tokenize a line of code.
 func Tokenize(form string) (*Tokens, error) {
+	return tokenize(form, mark{src: &source{text: form, first: 1}, line: 1, col: 1})
+}
+
+// tokenize a form read from a source, starting at the given position.
+func tokenize(form string, start mark) (*Tokens, error) {
 	results := &Tokens{
 		Tokens: make([]Token, 0),
 		word:   make([]rune, 0),
 		form:   form,
-		kind:   ASymbol,
+		at:     start,
+		prev:   start,
 	}
 
-	for _, c := range form {
+	runes := []rune(form)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
 		switch c {
 
 		case '(':
+			results.pushWord()
 			results.pushToken(AOpenParen, "(")
 
 		case ')':
 			results.pushWord()
 			results.pushToken(ACloseParen, ")")
 
-		case '"':
-			if results.emptyWord() {
-				results.setKind(AString)
+		case '{':
+			if string(results.word) == "#" {
+				results.openSet()
 			} else {
 				results.pushWord()
+				results.pushToken(AOpenBrace, "{")
 			}
 
-		case ',': // Treat commas as whitespace.
-			if results.inString() {
-				results.pushChar(c)
+		case '}':
+			results.pushWord()
+			results.pushToken(ACloseBrace, "}")
+
+		case '[':
+			results.pushWord()
+			results.pushToken(AOpenBracket, "[")
+
+		case ']':
+			results.pushWord()
+			results.pushToken(ACloseBracket, "]")
+
+		case '"':
+			results.pushWord()
+			last, err := results.pushString(runes, i)
+			if err != nil {
+				return nil, err
 			}
+			i = last
+
+		case ',': // Treat commas as whitespace.
+			results.pushWord()
 
 		case ' ', '\t', '\r', '\n':
-			if results.inString() {
+			results.pushWord()
+
+		case '\'':
+			if results.emptyWord() {
+				results.pushToken(AQuote, "'")
+			} else {
 				results.pushChar(c)
+			}
+
+		case '`':
+			if results.emptyWord() {
+				results.pushToken(AQuasiQuote, "`")
 			} else {
-				results.pushWord()
+				results.pushChar(c)
 			}
 
-		case '\'':
+		case '~':
 			if results.emptyWord() {
-				results.pushToken(AQuote, "'")
+				results.pushToken(AUnquote, "~")
+			} else {
+				results.pushChar(c)
+			}
+
+		case '@':
+			if results.emptyWord() && results.follows(AUnquote) {
+				results.spliceUnquote()
 			} else {
 				results.pushChar(c)
 			}
@@ -155,8 +280,100 @@ func Tokenize(form string) (*Tokens, error) {
 		default:
 			results.pushChar(c)
 		}
+		results.advance(runes[i])
 	}
 	results.pushWord()
 
 	return results, nil
 }
+
+//-----------------------------------------------------------------------------
+// Strings
+//-----------------------------------------------------------------------------
+
+// A string is either "plain", where a backslash escapes \" \\ \n \t \r
+// or \uXXXX (any other backslash is kept, so regular expressions like
+// "\d+" read as written), or """raw""", where nothing is escaped. Both
+// can span lines. The lexer, the comment stripper and the reader all
+// find the end of a string with closeQuote, so they agree on which
+// parens and semi-colons are inside one.
+
+const (
+	plainQuote = `"`
+	rawQuote   = `"""`
+)
+
+// openQuote returns the quote that opens the string at the start of
+// runes.
+func openQuote(runes []rune) string {
+	if hasPrefix(runes, rawQuote) {
+		return rawQuote
+	}
+	return plainQuote
+}
+
+func hasPrefix(runes []rune, prefix string) bool {
+	n := len([]rune(prefix))
+	return len(runes) >= n && string(runes[:n]) == prefix
+}
+
+// closeQuote returns the number of runes up to and including the quote
+// ending a string opened with quote, or -1 if the string doesn't end
+// in runes.
+func closeQuote(runes []rune, quote string) int {
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case quote == plainQuote && runes[i] == '\\':
+			i++
+		case quote == plainQuote && runes[i] == '"':
+			return i + 1
+		case quote == rawQuote && hasPrefix(runes[i:], rawQuote):
+			// A raw string can end with quotes: """say "hi"""" is
+			// `say "hi"`.
+			for i+3 < len(runes) && runes[i+3] == '"' {
+				i++
+			}
+			return i + 3
+		}
+	}
+	return -1
+}
+
+// unescape returns the value of a plain string's content.
+func unescape(content []rune) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if c != '\\' || i+1 == len(content) {
+			b.WriteRune(c)
+			continue
+		}
+
+		i++
+		switch content[i] {
+		case '"', '\\':
+			b.WriteRune(content[i])
+		case 'n':
+			b.WriteRune('\n')
+		case 't':
+			b.WriteRune('\t')
+		case 'r':
+			b.WriteRune('\r')
+		case 'u':
+			if i+5 > len(content) {
+				return "", fmt.Errorf("invalid escape '\\u%v' in string", string(content[i+1:]))
+			}
+			hex := string(content[i+1 : i+5])
+			code, err := strconv.ParseUint(hex, 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape '\\u%v' in string", hex)
+			}
+			b.WriteRune(rune(code))
+			i += 4
+		default:
+			b.WriteRune('\\')
+			b.WriteRune(content[i])
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,395 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import "fmt"
+
+// Macros are expanded in a phase before each top level form is
+// evaluated, so a macro used in a function body is expanded once,
+// when the function is defined. Forms that reach the evaluator
+// unexpanded, such as those built at run time, or using a macro
+// defined later, are expanded when they're evaluated.
+
+//-----------------------------------------------------------------------------
+// DEFMACRO
+//-----------------------------------------------------------------------------
+
+func evalDefmacro(env *Environment, args Expression) (Expression, error) {
+	sig := "(defmacro name (params) body…)"
+
+	if err := typeCheck(sig, args.list, ckArityAtLeast(2)); err != nil {
+		return NilExpression, err
+	}
+
+	name := args.list[0]
+	params := args.list[1]
+
+	if !name.IsSymbol() {
+		return nilExpr("%v «-- name must be a symbol", sig)
+	}
+
+	if !params.IsList() {
+		return nilExpr("%v «-- params must be a list", sig)
+	}
+
+	m := NewMacroExpr(env, name, params, NewListExpr(args.list[2:]))
+	if m.signature.err != nil {
+		return NilExpression, m.signature.err
+	}
+
+	env.Set(name, m)
+	return m, nil
+}
+
+//-----------------------------------------------------------------------------
+// EXPANSION
+//-----------------------------------------------------------------------------
+
+// expandMacro calls a macro with the unevaluated arguments of a call,
+// returning the form the call expands to.
+func expandMacro(interp Interpreter, macro, call Expression) (Expression, error) {
+	args := call.list[1:]
+
+	if ok, err := isValidArity(macro, args); !ok {
+		return NilExpression, annotate(err, call.span)
+	}
+
+	env, err := macro.functionEnv.bindArgs(macro, args, interp.Evaluate)
+	if err != nil {
+		return NilExpression, withFrame(err, macro, call.span)
+	}
+
+	form, err := interp.Evaluate(env, *macro.functionBody)
+	if err != nil {
+		return NilExpression, withFrame(err, macro, call.span)
+	}
+
+	// Errors in generated code are reported at the macro call.
+	if form.span == nil {
+		form.span = call.span
+	}
+	return form, nil
+}
+
+// macroexpand1 expands form once if it's a macro call.
+func macroexpand1(interp Interpreter, env *Environment, form Expression) (Expression, bool, error) {
+	if !form.IsList() || len(form.list) == 0 || !form.list[0].IsSymbol() {
+		return form, false, nil
+	}
+
+	found, macro := env.Lookup(form.list[0].symbol)
+	if !found || !macro.IsMacro() {
+		return form, false, nil
+	}
+
+	expanded, err := expandMacro(interp, macro, form)
+	return expanded, err == nil, err
+}
+
+// macroexpand expands form until it's no longer a macro call.
+func macroexpand(interp Interpreter, env *Environment, form Expression) (Expression, bool, error) {
+	changed := false
+	for {
+		expanded, ok, err := macroexpand1(interp, env, form)
+		if err != nil || !ok {
+			return expanded, changed, err
+		}
+		form, changed = expanded, true
+	}
+}
+
+// expandAll expands every macro call in form, leaving quoted data,
+// names and parameter lists alone.
+func expandAll(interp Interpreter, env *Environment, form Expression) (Expression, bool, error) {
+	form, changed, err := macroexpand(interp, env, form)
+	if err == nil && (form.IsVector() || form.IsHashMap() || form.IsSet()) {
+		return expandCollection(interp, env, form, changed)
+	}
+	if err != nil || !form.IsList() || len(form.list) == 0 {
+		return form, changed, err
+	}
+
+	skip := func(i int) bool { return false }
+
+	switch form.list[0].symbol {
+	case "quasiquote":
+		return expandUnquoted(interp, env, form, changed)
+	case "defun", "defmacro":
+		skip = func(i int) bool { return i < 3 }
+	case "fn", "lambda":
+		skip = func(i int) bool { return i < 2 }
+	case "let":
+		// A named let's bindings follow its name.
+		at := 1
+		if len(form.list) > 1 && form.list[1].IsSymbol() {
+			at = 2
+		}
+		if len(form.list) > at && form.list[at].IsList() {
+			bindings, ok, err := expandEach(interp, env, form.list[at], func(i int) bool {
+				return i%2 == 0
+			})
+			if err != nil {
+				return NilExpression, false, err
+			}
+			if ok {
+				form, changed = replaceAt(form, at, bindings), true
+			}
+		}
+		skip = func(i int) bool { return i <= at }
+	case "case":
+		skip = func(i int) bool { return i >= 2 && (i%2 == 0) && i != len(form.list)-1 }
+	}
+
+	expanded, ok, err := expandEach(interp, env, form, skip)
+	return expanded, changed || ok, err
+}
+
+// expandEach expands the elements of a list, copying it only if one
+// of them changes.
+func expandEach(interp Interpreter, env *Environment, form Expression, skip func(int) bool) (Expression, bool, error) {
+	var list []Expression
+
+	for i, e := range form.list {
+		if skip(i) {
+			continue
+		}
+
+		expanded, changed, err := expandAll(interp, env, e)
+		if err != nil {
+			return NilExpression, false, err
+		}
+
+		if changed {
+			if list == nil {
+				list = append([]Expression{}, form.list...)
+			}
+			list[i] = expanded
+		}
+	}
+
+	if list == nil {
+		return form, false, nil
+	}
+	return withSpanOf(NewListExpr(list), form), true, nil
+}
+
+// expandCollection expands the elements of a vector, set or hash-map
+// literal, copying it only if one of them changes.
+func expandCollection(interp Interpreter, env *Environment, form Expression, changed bool) (Expression, bool, error) {
+	if form.IsVector() {
+		expanded, ok, err := expandEach(interp, env, form, func(i int) bool { return false })
+		if err != nil || !ok {
+			return form, changed, err
+		}
+		return withSpanOf(NewVectorExpr(expanded.list), form), true, nil
+	}
+
+	m, ok := newHakiMap(), false
+	for _, key := range form.hashMap.sortedKeys() {
+		k, kChanged, err := expandAll(interp, env, key)
+		if err != nil {
+			return NilExpression, false, err
+		}
+		value, _ := form.hashMap.get(key)
+		v, vChanged, err := expandAll(interp, env, value)
+		if err != nil {
+			return NilExpression, false, err
+		}
+		m.set(k, v)
+		ok = ok || kChanged || vChanged
+	}
+
+	if !ok {
+		return form, changed, nil
+	}
+	if form.IsSet() {
+		return withSpanOf(newSetExpr(m), form), true, nil
+	}
+	return withSpanOf(NewHashMapExpr(m), form), true, nil
+}
+
+// expandUnquoted expands the unquoted parts of a quasiquote template.
+func expandUnquoted(interp Interpreter, env *Environment, form Expression, changed bool) (Expression, bool, error) {
+	if !form.IsList() {
+		return form, changed, nil
+	}
+
+	if isUnquote(form) {
+		expanded, ok, err := expandAll(interp, env, form.list[1])
+		if err != nil || !ok {
+			return form, changed, err
+		}
+		return replaceAt(form, 1, expanded), true, nil
+	}
+
+	var list []Expression
+	for i, e := range form.list {
+		expanded, ok, err := expandUnquoted(interp, env, e, false)
+		if err != nil {
+			return NilExpression, false, err
+		}
+		if ok {
+			if list == nil {
+				list = append([]Expression{}, form.list...)
+			}
+			list[i] = expanded
+		}
+	}
+
+	if list == nil {
+		return form, changed, nil
+	}
+	return withSpanOf(NewListExpr(list), form), true, nil
+}
+
+func replaceAt(form Expression, i int, e Expression) Expression {
+	list := append([]Expression{}, form.list...)
+	list[i] = e
+	return withSpanOf(NewListExpr(list), form)
+}
+
+func withSpanOf(e, form Expression) Expression {
+	e.span = form.span
+	return e
+}
+
+//-----------------------------------------------------------------------------
+// QUASIQUOTE
+//-----------------------------------------------------------------------------
+
+func isUnquote(form Expression) bool {
+	return form.IsList() && len(form.list) == 2 &&
+		(form.list[0].symbol == "unquote" || form.list[0].symbol == "unquote-splicing")
+}
+
+// quasiquote returns a template with its unquoted forms replaced by
+// their values, and its spliced forms by their elements.
+func quasiquote(interp Interpreter, env *Environment, template Expression) (Expression, error) {
+	switch template.tag {
+
+	case ExpQuote:
+		quoted, err := quasiquote(interp, env, *template.quote)
+		if err != nil {
+			return NilExpression, err
+		}
+		return NewExpr(ExpQuote, quoted), nil
+
+	case ExpList:
+		if isUnquote(template) {
+			if template.list[0].symbol == "unquote-splicing" {
+				return nilExpr("~@ must be used inside a list, vector or set")
+			}
+			return interp.Evaluate(env, template.list[1])
+		}
+
+		list, err := quasiquoteElems(interp, env, template)
+		if err != nil {
+			return NilExpression, err
+		}
+		return withSpanOf(NewListExpr(list), template), nil
+
+	case ExpVector, ExpHashMap, ExpSet:
+		values, err := quasiquoteElems(interp, env, template)
+		if err != nil {
+			return NilExpression, err
+		}
+		return withSpanOf(fromLiteral(template, values), template), nil
+
+	case ExpSymbol:
+		return env.qualify(template), nil
+
+	default:
+		return template, nil
+	}
+}
+
+// quasiquoteElems expands the elements of a list, vector, set or
+// hash-map template, in the order fromLiteral expects them.
+func quasiquoteElems(interp Interpreter, env *Environment, template Expression) ([]Expression, error) {
+	elems := templateElems(template)
+	values := make([]Expression, 0, len(elems))
+	for _, e := range elems {
+		if isSplice(e) {
+			if template.tag == ExpHashMap {
+				return nil, fmt.Errorf("~@ can't be used inside a hash-map")
+			}
+			spliced, err := interp.Evaluate(env, e.list[1])
+			if err != nil {
+				return nil, err
+			}
+			if !spliced.IsList() && !spliced.IsNil() {
+				return nil, fmt.Errorf("~@ expects a list, not '%v'", spliced.Type())
+			}
+			values = append(values, spliced.list...)
+			continue
+		}
+
+		expanded, err := quasiquote(interp, env, e)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, expanded)
+	}
+	return values, nil
+}
+
+func isSplice(form Expression) bool {
+	return isUnquote(form) && form.list[0].symbol == "unquote-splicing"
+}
+
+// templateElems returns the forms in a list template, or in a
+// collection template as literalElems does.
+func templateElems(template Expression) []Expression {
+	if template.tag == ExpList {
+		return template.list
+	}
+	return literalElems(template)
+}
+
+//-----------------------------------------------------------------------------
+// Special forms
+//-----------------------------------------------------------------------------
+
+func evalQuasiquote(interp Interpreter, env *Environment, args Expression) (Expression, error) {
+	if err := typeCheck("(quasiquote form)", args.list, ckArity(1)); err != nil {
+		return NilExpression, err
+	}
+	return quasiquote(interp, env, args.list[0])
+}
+
+func evalMacroexpand(interp Interpreter, env *Environment, args Expression, once bool) (Expression, error) {
+	sig := "(macroexpand form)"
+	if once {
+		sig = "(macroexpand-1 form)"
+	}
+
+	if err := typeCheck(sig, args.list, ckArity(1)); err != nil {
+		return NilExpression, err
+	}
+
+	form, err := interp.Evaluate(env, args.list[0])
+	if err != nil {
+		return NilExpression, err
+	}
+
+	if once {
+		form, _, err = macroexpand1(interp, env, form)
+	} else {
+		form, _, err = macroexpand(interp, env, form)
+	}
+	return form, err
+}
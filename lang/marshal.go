@@ -0,0 +1,468 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// Conversion between Go values and haki expressions.
+//
+// Structs become hash-maps keyed by symbols named after each exported
+// field. A `haki:"name"` struct tag renames the field, and `haki:"-"`
+// skips it. Go maps become hash-maps keyed by the converted map keys.
+// Keywords convert to their names, and vectors and sets to slices.
+
+var expressionType = reflect.TypeOf(Expression{})
+
+// FromGo converts a Go value into a haki expression.
+func FromGo(v interface{}) (Expression, error) {
+	if v == nil {
+		return NilExpression, nil
+	}
+	return fromValue(reflect.ValueOf(v))
+}
+
+// ToGo stores the Go equivalent of an expression in the value pointed
+// to by target.
+func ToGo(expr Expression, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("ToGo target must be a non-nil pointer")
+	}
+	return toValue(expr, rv.Elem())
+}
+
+//-----------------------------------------------------------------------------
+// Go → haki
+//-----------------------------------------------------------------------------
+
+func fromValue(v reflect.Value) (Expression, error) {
+
+	if v.Type() == expressionType {
+		return v.Interface().(Expression), nil
+	}
+
+	switch v.Kind() {
+
+	case reflect.Invalid:
+		return NilExpression, nil
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return NilExpression, nil
+		}
+		return fromValue(v.Elem())
+
+	case reflect.Bool:
+		return NewBoolExpr(v.Bool()), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewIntExpr(v.Int()), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			return nilExpr("FromGo: %v overflows a haki integer", u)
+		}
+		return NewIntExpr(int64(u)), nil
+
+	case reflect.Float32, reflect.Float64:
+		return NewExpr(ExpFloat, v.Float()), nil
+
+	case reflect.String:
+		return NewStringExpr(v.String()), nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NewListExpr([]Expression{}), nil
+		}
+		list := make([]Expression, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e, err := fromValue(v.Index(i))
+			if err != nil {
+				return NilExpression, err
+			}
+			list = append(list, e)
+		}
+		return NewListExpr(list), nil
+
+	case reflect.Map:
+		m := newHakiMap()
+		for _, key := range v.MapKeys() {
+			k, err := fromValue(key)
+			if err != nil {
+				return NilExpression, err
+			}
+			val, err := fromValue(v.MapIndex(key))
+			if err != nil {
+				return NilExpression, err
+			}
+			m.set(k, val)
+		}
+		return NewHashMapExpr(m), nil
+
+	case reflect.Struct:
+		m := newHakiMap()
+		for _, f := range structFields(v.Type()) {
+			field, ok := fieldByIndexOK(v, f.index)
+			if !ok {
+				continue
+			}
+			val, err := fromValue(field)
+			if err != nil {
+				return NilExpression, err
+			}
+			m.set(hSym(f.name), val)
+		}
+		return NewHashMapExpr(m), nil
+
+	default:
+		return nilExpr("FromGo: unable to convert Go %v", v.Type())
+	}
+}
+
+//-----------------------------------------------------------------------------
+// haki → Go
+//-----------------------------------------------------------------------------
+
+func convError(expr Expression, t reflect.Type) error {
+	return fmt.Errorf("ToGo: unable to convert haki %v to Go %v", expr.Type(), t)
+}
+
+func toValue(expr Expression, v reflect.Value) error {
+	t := v.Type()
+
+	if t == expressionType {
+		v.Set(reflect.ValueOf(expr))
+		return nil
+	}
+
+	if expr.tag == ExpNil {
+		v.Set(reflect.Zero(t))
+		return nil
+	}
+
+	switch t.Kind() {
+
+	case reflect.Ptr:
+		elem := reflect.New(t.Elem())
+		if err := toValue(expr, elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return convError(expr, t)
+		}
+		nv, err := toInterface(expr)
+		if err != nil {
+			return err
+		}
+		if nv != nil {
+			v.Set(reflect.ValueOf(nv))
+		}
+		return nil
+
+	case reflect.Bool:
+		if expr.tag != ExpBool {
+			return convError(expr, t)
+		}
+		v.SetBool(expr.bool)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if expr.tag != ExpInteger {
+			return convError(expr, t)
+		}
+		if v.OverflowInt(expr.integer) {
+			return fmt.Errorf("ToGo: %v overflows Go %v", expr.integer, t)
+		}
+		v.SetInt(expr.integer)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if expr.tag != ExpInteger {
+			return convError(expr, t)
+		}
+		if expr.integer < 0 || v.OverflowUint(uint64(expr.integer)) {
+			return fmt.Errorf("ToGo: %v overflows Go %v", expr.integer, t)
+		}
+		v.SetUint(uint64(expr.integer))
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		switch expr.tag {
+		case ExpFloat:
+			v.SetFloat(expr.float)
+		case ExpInteger:
+			v.SetFloat(float64(expr.integer))
+		default:
+			return convError(expr, t)
+		}
+		return nil
+
+	case reflect.String:
+		switch expr.tag {
+		case ExpString:
+			v.SetString(expr.string)
+		case ExpSymbol:
+			v.SetString(expr.symbol)
+		case ExpKeyword:
+			v.SetString(expr.symbol[1:])
+		default:
+			return convError(expr, t)
+		}
+		return nil
+
+	case reflect.Slice:
+		if expr.tag != ExpList && expr.tag != ExpVector {
+			return convError(expr, t)
+		}
+		slice := reflect.MakeSlice(t, len(expr.list), len(expr.list))
+		for i, e := range expr.list {
+			if err := toValue(e, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+
+	case reflect.Array:
+		if expr.tag != ExpList && expr.tag != ExpVector {
+			return convError(expr, t)
+		}
+		if len(expr.list) != t.Len() {
+			return fmt.Errorf("ToGo: can't store a list of %v values in Go %v",
+				len(expr.list), t)
+		}
+		for i, e := range expr.list {
+			if err := toValue(e, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		if expr.tag != ExpHashMap {
+			return convError(expr, t)
+		}
+		m := reflect.MakeMapWithSize(t, expr.hashMap.size())
+		for _, entry := range expr.hashMap.entries() {
+			k := reflect.New(t.Key()).Elem()
+			if err := toValue(entry.key, k); err != nil {
+				return err
+			}
+			val := reflect.New(t.Elem()).Elem()
+			if err := toValue(entry.value, val); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, val)
+		}
+		v.Set(m)
+		return nil
+
+	case reflect.Struct:
+		if expr.tag != ExpHashMap {
+			return convError(expr, t)
+		}
+		fields := structFields(t)
+		for _, entry := range expr.hashMap.entries() {
+			name, ok := keyName(entry.key)
+			if !ok {
+				continue
+			}
+			f, found := findField(fields, name)
+			if !found {
+				continue
+			}
+			field, err := fieldByIndexAlloc(v, f.index)
+			if err != nil {
+				return err
+			}
+			if err := toValue(entry.value, field); err != nil {
+				return fmt.Errorf("%v (field '%v')", err, f.name)
+			}
+		}
+		return nil
+
+	default:
+		return convError(expr, t)
+	}
+}
+
+// toInterface returns the natural Go representation of an expression.
+func toInterface(expr Expression) (interface{}, error) {
+	switch expr.tag {
+	case ExpNil:
+		return nil, nil
+	case ExpBool:
+		return expr.bool, nil
+	case ExpInteger:
+		return expr.integer, nil
+	case ExpFloat:
+		return expr.float, nil
+	case ExpString:
+		return expr.string, nil
+	case ExpSymbol:
+		return expr.symbol, nil
+	case ExpKeyword:
+		return expr.symbol[1:], nil
+	case ExpList, ExpVector, ExpSet:
+		elems := expr.list
+		if expr.tag == ExpSet {
+			elems = expr.hashMap.sortedKeys()
+		}
+		list := make([]interface{}, 0, len(elems))
+		for _, e := range elems {
+			v, err := toInterface(e)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case ExpHashMap:
+		m := make(map[string]interface{}, expr.hashMap.size())
+		for _, entry := range expr.hashMap.entries() {
+			name, ok := keyName(entry.key)
+			if !ok {
+				name = entry.key.String()
+			}
+			v, err := toInterface(entry.value)
+			if err != nil {
+				return nil, err
+			}
+			m[name] = v
+		}
+		return m, nil
+	default:
+		return nil, convError(expr, reflect.TypeOf((*interface{})(nil)).Elem())
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Struct field support
+//-----------------------------------------------------------------------------
+
+type fieldInfo struct {
+	name  string
+	index []int
+}
+
+func structFields(t reflect.Type) []fieldInfo {
+	fields := make([]fieldInfo, 0)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("haki")
+
+		if tag == "-" {
+			continue
+		}
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if sf.Anonymous && tag == "" && ft.Kind() == reflect.Struct {
+			for _, inner := range structFields(ft) {
+				index := append([]int{i}, inner.index...)
+				fields = append(fields, fieldInfo{name: inner.name, index: index})
+			}
+			continue
+		}
+
+		if sf.PkgPath != "" { // unexported
+			continue
+		}
+
+		name := sf.Name
+		if tag != "" {
+			name = tag
+		}
+		fields = append(fields, fieldInfo{name: name, index: []int{i}})
+	}
+	return fields
+}
+
+func findField(fields []fieldInfo, name string) (fieldInfo, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return fieldInfo{}, false
+}
+
+// fieldByIndexAlloc is reflect.FieldByIndex, but allocates nil embedded
+// struct pointers along the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return v, fmt.Errorf("ToGo: can't set embedded pointer %v", v.Type())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// fieldByIndexOK is reflect.FieldByIndex, but reports false rather
+// than panicking when it reaches a nil embedded struct pointer, whose
+// promoted fields are then skipped.
+func fieldByIndexOK(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func keyName(key Expression) (string, bool) {
+	switch key.tag {
+	case ExpString:
+		return key.string, true
+	case ExpSymbol:
+		return key.symbol, true
+	case ExpKeyword:
+		return key.symbol[1:], true
+	}
+	return "", false
+}
@@ -0,0 +1,230 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A library is a file starting with (ns name), found by require at
+// name.hk (with dots in the name as directories) in the requiring
+// script's directory or on the load path. Its definitions are made
+// in its namespace, and used from other scripts as name/def or, with
+// an alias, as alias/def.
+
+// sessionOf returns the session an interpreter's builtins are bound to.
+func sessionOf(interp Interpreter) *session {
+	switch x := interp.(type) {
+	case TcoInterpreter:
+		return x.session
+	case NaiveInterpreter:
+		return x.session
+	case VMInterpreter:
+		return x.session
+	}
+	panic(fmt.Sprintf("unknown interpreter %T", interp))
+}
+
+// scriptDir returns the directory of the script form was read from,
+// or the working directory if it wasn't read from a file.
+func scriptDir(s *session, form Expression) string {
+	if form.span == nil || form.span.src == nil {
+		return s.dir
+	}
+	name := form.span.src.name
+	if name == "" || strings.HasPrefix(name, "<") {
+		return s.dir
+	}
+	return filepath.Dir(s.absPath(name))
+}
+
+func checkLoadable(s *session, builtin string) error {
+	if !s.capabilities.Allows(CapFileRead) {
+		return &PermissionError{
+			Builtin: builtin,
+			Reason:  fmt.Sprintf("requires the '%v' capability", CapFileRead),
+		}
+	}
+	return nil
+}
+
+// runFile evaluates the forms in a file, returning the value of the
+// last.
+func runFile(interp Interpreter, s *session, env *Environment, builtin, path string) (Expression, error) {
+	path, err := s.checkPath(builtin, path)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	text := string(buffer)
+	if strings.HasPrefix(text, "#!") {
+		// Keep the line break, so positions still match the file.
+		if i := strings.Index(text, "\n"); i >= 0 {
+			text = text[i:]
+		} else {
+			text = ""
+		}
+	}
+
+	reader := NewReader()
+	reader.AppendSource(path, text)
+	return runner(interp, env, reader)
+}
+
+//-----------------------------------------------------------------------------
+// NS
+//-----------------------------------------------------------------------------
+
+// evalNs makes name the namespace for the definitions that follow it.
+func evalNs(env *Environment, args Expression) (Expression, error) {
+	sig := "(ns name)"
+	if err := typeCheck(sig, args.list, ckArity(1), ckType(0, ExpSymbol)); err != nil {
+		return NilExpression, err
+	}
+
+	name := args.list[0].symbol
+	if strings.Contains(name, "/") {
+		return nilExpr("%v «-- '%v' can't contain '/'", sig, name)
+	}
+
+	env.ns = newNamespace(name)
+	return NilExpression, nil
+}
+
+//-----------------------------------------------------------------------------
+// REQUIRE
+//-----------------------------------------------------------------------------
+
+// evalRequire loads a library, unless it's already been loaded, and
+// optionally gives it an alias in the current namespace.
+func evalRequire(interp Interpreter, env *Environment, form Expression) (Expression, error) {
+	sig := "(require 'name :as alias)"
+
+	// The name's evaluated, and the alias isn't.
+	args := append([]Expression{}, form.list[1:]...)
+	if err := typeCheck(sig, args, ckArityOneOf(1, 3)); err != nil {
+		return NilExpression, err
+	}
+
+	value, err := interp.Evaluate(env, args[0])
+	if err != nil {
+		return NilExpression, err
+	}
+	args[0] = value
+
+	if err := typeCheck(sig, args, ckType(0, ExpSymbol)); err != nil {
+		return NilExpression, err
+	}
+	if len(args) == 3 && (!isKeyword(args[1], ":as") || !args[2].IsSymbol()) {
+		return nilExpr("%v «-- expects ':as' and an alias after the name", sig)
+	}
+
+	s := sessionOf(interp)
+	name := args[0].symbol
+
+	if !s.required[name] {
+		if err := requireLibrary(interp, s, env, form, name); err != nil {
+			return NilExpression, err
+		}
+	}
+
+	if len(args) == 3 && env.ns != nil {
+		env.ns.aliases[args[2].symbol] = name
+	}
+	return NilExpression, nil
+}
+
+func requireLibrary(interp Interpreter, s *session, env *Environment, form Expression, name string) error {
+	if err := checkLoadable(s, "require"); err != nil {
+		return err
+	}
+
+	file := strings.Replace(name, ".", string(filepath.Separator), -1) + ".hk"
+	dirs := append([]string{scriptDir(s, form)}, s.loadPath...)
+
+	path := ""
+	for _, dir := range dirs {
+		if info, err := os.Stat(filepath.Join(dir, file)); err == nil && !info.IsDir() {
+			path = filepath.Join(dir, file)
+			break
+		}
+	}
+
+	if path == "" {
+		return fmt.Errorf("(require '%v) «-- can't find '%v' in %v",
+			name, file, strings.Join(dirs, ", "))
+	}
+
+	// Marked before loading, so libraries requiring each other
+	// don't load forever.
+	s.required[name] = true
+
+	libEnv := &Environment{global: env.global, ns: newNamespace("")}
+	if _, err := runFile(interp, s, libEnv, "require", path); err != nil {
+		delete(s.required, name)
+		return err
+	}
+
+	if libEnv.ns.name != name {
+		delete(s.required, name)
+		return fmt.Errorf("(require '%v) «-- '%v' must declare (ns %v)", name, path, name)
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// LOAD
+//-----------------------------------------------------------------------------
+
+// evalLoad evaluates the forms in a file, relative to the directory of
+// the script loading it, in the current namespace.
+func evalLoad(interp Interpreter, env *Environment, form Expression) (Expression, error) {
+	sig := "(load path)"
+	if err := typeCheck(sig, form.list[1:], ckArity(1)); err != nil {
+		return NilExpression, err
+	}
+
+	arg, err := interp.Evaluate(env, form.list[1])
+	if err != nil {
+		return NilExpression, err
+	}
+	if err := typeCheck(sig, []Expression{arg}, ckString(0)); err != nil {
+		return NilExpression, err
+	}
+
+	s := sessionOf(interp)
+	if err := checkLoadable(s, "load"); err != nil {
+		return NilExpression, err
+	}
+
+	path := arg.string
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(scriptDir(s, form), path)
+	}
+
+	loadEnv := &Environment{global: env.global, ns: env.ns}
+	return runFile(interp, s, loadEnv, "load", path)
+}
@@ -0,0 +1,99 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Option configures an interpreter when it's created.
+type Option func(*config)
+
+type config struct {
+	limits       Limits
+	capabilities Capability
+	executables  []string
+	fileRoots    []string
+	stdin        io.Reader
+	stdout       io.Writer
+	stderr       io.Writer
+	dir          string
+	snapshot     *Snapshot
+	loadPath     []string
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		capabilities: Full,
+		stdin:        os.Stdin,
+		stdout:       os.Stdout,
+		stderr:       os.Stderr,
+		loadPath:     filepath.SplitList(os.Getenv("HAKI_PATH")),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithLimits bounds the resources a script may consume.
+func WithLimits(limits Limits) Option {
+	return func(c *config) {
+		c.limits = limits
+	}
+}
+
+// WithStdin sets the stream read by *stdin*.
+func WithStdin(r io.Reader) Option {
+	return func(c *config) {
+		c.stdin = r
+	}
+}
+
+// WithStdout sets the stream prn and shell! processes write to.
+func WithStdout(w io.Writer) Option {
+	return func(c *config) {
+		c.stdout = w
+	}
+}
+
+// WithStderr sets the stream shell! processes write errors to.
+func WithStderr(w io.Writer) Option {
+	return func(c *config) {
+		c.stderr = w
+	}
+}
+
+// WithWorkingDir sets the interpreter's initial working directory,
+// which defaults to the process's. Changing it with cd! doesn't
+// affect the process or other interpreters.
+func WithWorkingDir(dir string) Option {
+	return func(c *config) {
+		c.dir = dir
+	}
+}
+
+// WithLoadPath sets the directories require searches for libraries
+// after the requiring script's own directory. The default is the
+// HAKI_PATH environment variable's list.
+func WithLoadPath(dirs ...string) Option {
+	return func(c *config) {
+		c.loadPath = dirs
+	}
+}
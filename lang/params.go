@@ -0,0 +1,189 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A parameter list names the required params, each a name or a
+// pattern (see destructure.go), then optionally `&opt`
+// and the optional params, each a name or a (name default) pair, then
+// optionally `&` and a name bound to a list of the remaining args:
+//
+//    (defun log (level &opt (prefix "> ") & msgs) ...)
+//
+// Defaults are evaluated when the function is called, after the
+// params before them are bound. Optional params without a default
+// are nil.
+
+const (
+	optMarker  = "&opt"
+	restMarker = "&"
+)
+
+type optionalParam struct {
+	name     Expression
+	fallback Expression
+}
+
+// signature is a parsed parameter list.
+type signature struct {
+	required []Expression
+	optional []optionalParam
+	rest     *Expression
+	err      error // set if the parameter list is malformed
+}
+
+func newSignature(params Expression) *signature {
+	sig := &signature{}
+	if err := sig.parse(params.list); err != nil {
+		sig.err = fmt.Errorf("in params %v: %v", params, err)
+	}
+	return sig
+}
+
+func (sig *signature) parse(params []Expression) error {
+	i := 0
+
+	for ; i < len(params) && !isMarker(params[i]); i++ {
+		if err := checkPattern(params[i]); err != nil {
+			return err
+		}
+		sig.required = append(sig.required, params[i])
+	}
+
+	if i < len(params) && params[i].symbol == optMarker {
+		for i++; i < len(params) && !isMarker(params[i]); i++ {
+			opt, err := newOptionalParam(params[i])
+			if err != nil {
+				return err
+			}
+			sig.optional = append(sig.optional, opt)
+		}
+	}
+
+	if i < len(params) && params[i].symbol == restMarker {
+		if i+2 != len(params) || !params[i+1].IsSymbol() || isMarker(params[i+1]) {
+			return fmt.Errorf("'%v' must be followed by exactly one name", restMarker)
+		}
+		sig.rest = &params[i+1]
+		i += 2
+	}
+
+	if i < len(params) {
+		return fmt.Errorf("'%v' must come before '%v'", optMarker, restMarker)
+	}
+	return nil
+}
+
+func newOptionalParam(p Expression) (optionalParam, error) {
+	if p.IsSymbol() {
+		return optionalParam{name: p, fallback: NilExpression}, nil
+	}
+
+	if p.IsList() && len(p.list) == 2 && p.list[0].IsSymbol() {
+		return optionalParam{name: p.list[0], fallback: p.list[1]}, nil
+	}
+
+	return optionalParam{}, fmt.Errorf("optional param '%v' must be a name or (name default)", p)
+}
+
+func isMarker(p Expression) bool {
+	return p.IsSymbol() && (p.symbol == optMarker || p.symbol == restMarker)
+}
+
+//-----------------------------------------------------------------------------
+// Arity
+//-----------------------------------------------------------------------------
+
+func (sig *signature) minArgs() int {
+	return len(sig.required)
+}
+
+// maxArgs returns the most args accepted, or -1 if unbounded.
+func (sig *signature) maxArgs() int {
+	if sig.rest != nil {
+		return -1
+	}
+	return len(sig.required) + len(sig.optional)
+}
+
+func (sig *signature) accepts(argc int) bool {
+	max := sig.maxArgs()
+	return argc >= sig.minArgs() && (max < 0 || argc <= max)
+}
+
+// describeArity returns the accepted number of args, e.g. "2",
+// "1 to 3" or "at least 1".
+func (sig *signature) describeArity() string {
+	min, max := sig.minArgs(), sig.maxArgs()
+	switch {
+	case max < 0:
+		return fmt.Sprintf("at least %v", min)
+	case min == max:
+		return fmt.Sprintf("%v", min)
+	default:
+		return fmt.Sprintf("%v to %v", min, max)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Binding
+//-----------------------------------------------------------------------------
+
+type evaluator func(env *Environment, expr Expression) (Expression, error)
+
+// bindArgs returns a copy of env with a function's params bound to
+// args, which must already have been checked with isValidArity.
+// Defaults for missing optional params are evaluated with eval.
+func (env *Environment) bindArgs(fn Expression, args []Expression, eval evaluator) (*Environment, error) {
+	sig := fn.signature
+	if sig == nil {
+		return nil, errors.New("no parameter list")
+	}
+
+	clone, frame := env.extend()
+
+	for i, p := range sig.required {
+		if err := bindPattern(frame, p, args[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	rest := args[len(sig.required):]
+	for _, opt := range sig.optional {
+		if len(rest) > 0 {
+			frame.bind(opt.name.symbol, rest[0])
+			rest = rest[1:]
+			continue
+		}
+
+		value, err := eval(clone, opt.fallback)
+		if err != nil {
+			return nil, err
+		}
+		frame.bind(opt.name.symbol, value)
+	}
+
+	if sig.rest != nil {
+		frame.bind(sig.rest.symbol, NewListExpr(append([]Expression{}, rest...)))
+	}
+
+	return clone, nil
+}
@@ -21,6 +21,13 @@ import (
 	"strconv"
 )
 
+// The forms reader shorthand expands to.
+var readerMacros = map[tokenType]string{
+	AQuasiQuote:      "quasiquote",
+	AUnquote:         "unquote",
+	AUnquoteSplicing: "unquote-splicing",
+}
+
 // Parser represents the state of the parser.
 type Parser struct {
 	tokens   []Token
@@ -41,40 +48,82 @@ func (p *Parser) Reset(tokens *Tokens) {
 	p.position = 0
 }
 
+// parseForm returns the s-expression in a form read from a source.
+func (p *Parser) parseForm(f form) (Expression, error) {
+	tokens, err := tokenize(f.text, f.start)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	p.Reset(tokens)
+	return p.Parse()
+}
+
 // Parse returns an s-expression suitable for interpretation.
 func (p *Parser) Parse() (Expression, error) {
 	token := p.next()
 	switch token.kind {
 
 	case AOpenParen:
-		return p.parseList()
+		return p.parseList(token)
+
+	case AOpenBrace:
+		return p.parseMap(token)
+
+	case AOpenBracket:
+		return p.parseVector(token)
+
+	case AOpenSet:
+		return p.parseSet(token)
 
 	case ASymbol:
-		return NewExpr(ExpSymbol, token.value), nil
+		if len(token.value) > 1 && token.value[0] == ':' {
+			return withSpan(NewExpr(ExpKeyword, token.value), token.start, token.end), nil
+		}
+		return withSpan(NewExpr(ExpSymbol, token.value), token.start, token.end), nil
 
 	case AString:
-		return NewExpr(ExpString, token.value), nil
+		return withSpan(NewExpr(ExpString, token.value), token.start, token.end), nil
 
 	case AInteger:
 		i, _ := strconv.ParseInt(token.value, 10, 64)
-		return NewExpr(ExpInteger, i), nil
+		return withSpan(NewExpr(ExpInteger, i), token.start, token.end), nil
 
 	case AFloat:
 		f, _ := strconv.ParseFloat(token.value, 64)
-		return NewExpr(ExpFloat, f), nil
+		return withSpan(NewExpr(ExpFloat, f), token.start, token.end), nil
 
 	case AQuote:
 		sexp, err := p.Parse()
 		if err != nil {
 			return sexp, err
 		}
-		return NewExpr(ExpQuote, sexp), nil
+		end := p.tokens[p.position-1].end
+		return withSpan(NewExpr(ExpQuote, sexp), token.start, end), nil
+
+	case AQuasiQuote, AUnquote, AUnquoteSplicing:
+		sexp, err := p.Parse()
+		if err != nil {
+			return sexp, err
+		}
+		end := p.tokens[p.position-1].end
+		form := NewListExpr([]Expression{hSym(readerMacros[token.kind]), sexp})
+		return withSpan(form, token.start, end), nil
 
 	default:
-		return NilExpression, fmt.Errorf("unable to process token '%v'", token)
+		return NilExpression, &Error{
+			Message: fmt.Sprintf("unable to process token '%v'", token),
+			Span:    *newSpan(token.start, token.end),
+		}
 	}
 }
 
+// withSpan records where in its source an expression was read.
+func withSpan(e Expression, start, end mark) Expression {
+	e.span = newSpan(start, end)
+	return e
+}
+
 func (p *Parser) pushBack() {
 	if p.position > 0 {
 		p.position = p.position - 1
@@ -91,33 +140,115 @@ func (p *Parser) notDone() bool {
 	return p.position+1 != len(p.tokens)
 }
 
-func (p *Parser) parseList() (Expression, error) {
+func (p *Parser) parseList(open Token) (Expression, error) {
+	list, end, err := p.parseUntil(ACloseParen, open)
+	if err != nil {
+		return NilExpression, err
+	}
+	return withSpan(NewExpr(ExpList, list), open.start, end), nil
+}
+
+// parseMap reads a {k v ...} literal as a hash-map of unevaluated
+// keys and values.
+func (p *Parser) parseMap(open Token) (Expression, error) {
+	elems, end, err := p.parseUntil(ACloseBrace, open)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	span := newSpan(open.start, end)
+	if len(elems)%2 != 0 {
+		return NilExpression, &Error{Message: "map literal must contain key/value pairs", Span: *span}
+	}
+
+	m := newHakiMap()
+	for i := 0; i < len(elems); i += 2 {
+		if _, found := m.get(elems[i]); found {
+			return NilExpression, &Error{Message: fmt.Sprintf("duplicate key '%v' in map literal", elems[i]), Span: *span}
+		}
+		m.put(elems[i], elems[i+1])
+	}
+
+	e := NewHashMapExpr(m)
+	e.span = span
+	return e, nil
+}
+
+// parseVector reads a [a b ...] literal as a vector of unevaluated
+// elements.
+func (p *Parser) parseVector(open Token) (Expression, error) {
+	elems, end, err := p.parseUntil(ACloseBracket, open)
+	if err != nil {
+		return NilExpression, err
+	}
+	return withSpan(NewVectorExpr(elems), open.start, end), nil
+}
+
+// parseSet reads a #{a b ...} literal as a set of unevaluated
+// elements.
+func (p *Parser) parseSet(open Token) (Expression, error) {
+	elems, end, err := p.parseUntil(ACloseBrace, open)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	span := newSpan(open.start, end)
+	set := NewSetExpr(elems)
+	if set.hashMap.size() != len(elems) {
+		return NilExpression, &Error{Message: "duplicate element in set literal", Span: *span}
+	}
+
+	set.span = span
+	return set, nil
+}
+
+func (p *Parser) parseUntil(closer tokenType, open Token) ([]Expression, mark, error) {
 	list := make([]Expression, 0)
+	end := open.end
+	closed := false
 
 done:
 	for p.notDone() {
 		token := p.next()
+		end = token.end
 
 		switch token.kind {
 
-		case AOpenParen:
-			sublist, err := p.parseList()
-			if err != nil {
-				return NilExpression, err
+		case ACloseParen, ACloseBrace, ACloseBracket:
+			if token.kind != closer {
+				return nil, end, &Error{
+					Message: fmt.Sprintf("unexpected '%v' closing '%v'", token.value, open.value),
+					Span:    *newSpan(token.start, token.end),
+				}
 			}
-			list = append(list, sublist)
-
-		case ACloseParen:
+			closed = true
 			break done
 
 		default:
 			p.pushBack()
 			atom, err := p.Parse()
 			if err != nil {
-				return atom, err
+				return nil, end, err
 			}
 			list = append(list, atom)
 		}
 	}
-	return NewExpr(ExpList, list), nil
+
+	// The final closer of a form is left unread.
+	if !closed && !p.notDone() && p.position < len(p.tokens) {
+		last := p.tokens[p.position]
+		end = last.end
+		if isCloserToken(last.kind) && last.kind != closer {
+			return nil, end, &Error{
+				Message: fmt.Sprintf("unexpected '%v' closing '%v'", last.value, open.value),
+				Span:    *newSpan(last.start, last.end),
+			}
+		}
+	}
+
+	return list, end, nil
+}
+
+func isCloserToken(kind tokenType) bool {
+	return kind == ACloseParen || kind == ACloseBrace || kind == ACloseBracket
 }
@@ -0,0 +1,76 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression.String prints data (nil, booleans, numbers, strings,
+// symbols, keywords, lists, vectors, sets and hash-maps) as it would
+// be written in a script, so reading the output with read-string gives
+// an equal value. Hash-map entries and set elements are printed in
+// order of their printed keys, so equal values print the same.
+// Functions, files and other values without a literal syntax print
+// in a form that can't be read back.
+
+func printList(elems []Expression) string {
+	parts := make([]string, 0, len(elems))
+	for _, e := range elems {
+		parts = append(parts, e.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// printString quotes s, escaping it so it reads back the same.
+func printString(s string) string {
+	var b strings.Builder
+	b.WriteRune('"')
+	for _, c := range s {
+		switch c {
+		case '"', '\\':
+			b.WriteRune('\\')
+			b.WriteRune(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if unicode.IsControl(c) {
+				fmt.Fprintf(&b, `\u%04x`, c)
+			} else {
+				b.WriteRune(c)
+			}
+		}
+	}
+	b.WriteRune('"')
+	return b.String()
+}
+
+// printFloat prints f so it reads back as a float, not an integer.
+func printFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if strings.ContainsAny(s, ".eIN") {
+		return s
+	}
+	return s + ".0"
+}
@@ -0,0 +1,250 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import "sync"
+
+// Program is a parsed script. One program can be run by many
+// interpreters, including concurrently.
+type Program struct {
+	forms []Expression
+
+	// The VM's code for each form, compiled the first time a VM runs
+	// the program, and shared by every VM that runs it after.
+	compiled sync.Once
+	procs    []*proc
+}
+
+// code returns the VM's code for each form of p.
+func (p *Program) code() []*proc {
+	p.compiled.Do(func() {
+		p.procs = make([]*proc, len(p.forms))
+		for i, form := range p.forms {
+			p.procs[i] = compileForm(form)
+		}
+	})
+	return p.procs
+}
+
+// Compile reads and parses the given sources, in order, into a
+// program.
+func Compile(sources ...string) (*Program, error) {
+	return CompileReader(NewReader(sources...))
+}
+
+// CompileReader parses the forms in a reader into a program. Use a
+// reader to give sources names for error messages.
+func CompileReader(reader *Reader) (*Program, error) {
+	forms, err := reader.readForms()
+	if err != nil {
+		return nil, err
+	}
+
+	parser := NewParser()
+	p := &Program{forms: make([]Expression, 0, len(forms))}
+
+	for _, f := range forms {
+		expr, err := parser.parseForm(f)
+		if err != nil {
+			return nil, err
+		}
+		p.forms = append(p.forms, expr)
+	}
+
+	return p, nil
+}
+
+// RunProgram evaluates each form of a compiled program.
+func (tco TcoInterpreter) RunProgram(p *Program) (Expression, error) {
+	defer tco.session.begin()()
+	return runForms(tco, tco.environment, p.forms)
+}
+
+// RunProgram evaluates each form of a compiled program.
+func (naive NaiveInterpreter) RunProgram(p *Program) (Expression, error) {
+	defer naive.session.begin()()
+	return runForms(naive, naive.environment, p.forms)
+}
+
+// RunProgram evaluates each form of a compiled program. The bytecode
+// for each form is kept with the program, so running it again doesn't
+// compile it again.
+func (vm VMInterpreter) RunProgram(p *Program) (Expression, error) {
+	defer vm.session.begin()()
+	return vm.runProgram(vm.environment, p)
+}
+
+func runForms(interpreter Interpreter, env *Environment, forms []Expression) (Expression, error) {
+	result := NilExpression
+	for _, form := range forms {
+		expr, _, err := expandAll(interpreter, env, form)
+		if err != nil {
+			return NilExpression, err
+		}
+		if result, err = interpreter.Evaluate(env, expr); err != nil {
+			return NilExpression, err
+		}
+	}
+	return result, nil
+}
+
+//-----------------------------------------------------------------------------
+// Snapshots
+//-----------------------------------------------------------------------------
+
+// Snapshot holds the definitions made by running a program, such as
+// Core, so new interpreters can start with them without evaluating
+// the program again. It's read-only and safe to share.
+type Snapshot struct {
+	defs map[string]Expression
+}
+
+// NewSnapshot runs a program in a fresh environment and captures the
+// definitions it makes.
+func NewSnapshot(p *Program) (*Snapshot, error) {
+	s := newSession(newConfig(nil))
+	env := newEnvironment([]string{}, s)
+
+	base := make(globals, len(env.global))
+	for k, v := range env.global {
+		base[k] = v
+	}
+
+	interp := TcoInterpreter{environment: env, parser: NewParser(), session: s}
+	if _, err := interp.RunProgram(p); err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{defs: make(map[string]Expression)}
+	force := thunkForcer{interp: interp, seen: make(map[*frameType]bool)}
+	for k, v := range env.global {
+		if old, found := base[k]; !found || !old.Equals(v) {
+			if err := force.value(v); err != nil {
+				return nil, err
+			}
+			snap.defs[k] = v
+		}
+	}
+	return snap, nil
+}
+
+// thunkForcer evaluates the let bindings in the frames a snapshot's
+// functions close over. The TCO interpreter, which takes snapshots,
+// binds them lazily, as thunks, which other interpreters can't use.
+type thunkForcer struct {
+	interp TcoInterpreter
+	seen   map[*frameType]bool
+}
+
+func (t thunkForcer) value(e Expression) error {
+	switch e.tag {
+	case ExpFunction, ExpLambda, ExpMacro:
+		return t.frames(e.functionEnv)
+	case ExpList, ExpVector:
+		for _, x := range e.list {
+			if err := t.value(x); err != nil {
+				return err
+			}
+		}
+	case ExpHashMap, ExpSet:
+		for _, entry := range e.hashMap.entries() {
+			if err := t.value(entry.key); err != nil {
+				return err
+			}
+			if err := t.value(entry.value); err != nil {
+				return err
+			}
+		}
+	case ExpQuote:
+		return t.value(*e.quote)
+	case ExpAtom:
+		return t.value(e.atom.get())
+	}
+	return nil
+}
+
+// frames forces the thunks bound in env's frames, evaluating each in
+// the frame it's bound in, and those reachable from their values.
+func (t thunkForcer) frames(env *Environment) error {
+	for f := env.frame; f != nil && !t.seen[f]; f = f.parent {
+		t.seen[f] = true
+		for i, slot := range f.slots {
+			if slot.IsThunk() {
+				scope := &Environment{global: env.global, frame: f, ns: env.ns}
+				value, err := t.interp.Evaluate(scope, *slot.functionBody)
+				if err != nil {
+					return err
+				}
+				f.slots[i], slot = value, value
+			}
+			if err := t.value(slot); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var coreSnapshot struct {
+	once sync.Once
+	snap *Snapshot
+	err  error
+}
+
+// CoreSnapshot returns a snapshot of the Core functions, computed the
+// first time it's needed.
+func CoreSnapshot() (*Snapshot, error) {
+	coreSnapshot.once.Do(func() {
+		p, err := Compile(Core)
+		if err != nil {
+			coreSnapshot.err = err
+			return
+		}
+		coreSnapshot.snap, coreSnapshot.err = NewSnapshot(p)
+	})
+	return coreSnapshot.snap, coreSnapshot.err
+}
+
+// WithSnapshot starts the interpreter with a snapshot's definitions,
+// as if the program it was taken from had been run.
+func WithSnapshot(snap *Snapshot) Option {
+	return func(c *config) {
+		c.snapshot = snap
+	}
+}
+
+// restore copies the snapshot's definitions into env. Functions keep
+// their closed over frames, but are rebound to env's globals so they
+// see this interpreter's builtins (and session) rather than those the
+// snapshot was taken with.
+func (snap *Snapshot) restore(env *Environment) {
+	if snap == nil {
+		return
+	}
+
+	for name, value := range snap.defs {
+		if value.IsFunction() || value.IsLambda() || value.IsMacro() {
+			fnEnv := value.functionEnv.Clone()
+			fnEnv.global = env.global
+			value.functionEnv = fnEnv
+		}
+		if value.IsAtomRef() {
+			value = NewAtomExpr(value.atom.get())
+		}
+		env.global[name] = value
+	}
+}
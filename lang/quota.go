@@ -0,0 +1,125 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import "fmt"
+
+// Limits are deterministic resource quotas for an interpreter. A zero
+// value means unlimited.
+type Limits struct {
+	MaxSteps      int64 // evaluation steps in each top-level call
+	MaxDepth      int   // nested (non-tail) function calls
+	MaxListLength int   // elements in a list returned by a builtin
+	MaxStringSize int   // bytes in a string returned by a builtin
+	MaxOpenFiles  int   // file handles open at the same time
+}
+
+// Quota names reported by QuotaExceededError.
+const (
+	QuotaSteps      = "steps"
+	QuotaDepth      = "depth"
+	QuotaListLength = "list-length"
+	QuotaStringSize = "string-size"
+	QuotaOpenFiles  = "open-files"
+)
+
+// QuotaExceededError is returned when a script goes over one of its
+// interpreter's Limits.
+type QuotaExceededError struct {
+	Quota string // one of the Quota* names
+	Limit int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded: %v limit of %v", e.Quota, e.Limit)
+}
+
+func quotaErr(quota string, limit int64) error {
+	return &QuotaExceededError{Quota: quota, Limit: limit}
+}
+
+// begin starts a top-level evaluation, such as a Run or a Call. Steps
+// are counted from zero for each, so MaxSteps bounds a single call
+// rather than the interpreter's whole life. Evaluations started while
+// one is running, such as a host function calling back into the
+// script, count against it. Call the returned function when it's done.
+func (s *session) begin() func() {
+	if s.running == 0 {
+		s.steps = 0
+	}
+	s.running++
+	return func() {
+		s.running--
+	}
+}
+
+// step counts one evaluation step, returning an error if evaluation
+// should stop.
+func (s *session) step() error {
+	if err := s.checkContext(); err != nil {
+		return err
+	}
+
+	s.steps++
+	if max := s.limits.MaxSteps; max > 0 && s.steps > max {
+		return quotaErr(QuotaSteps, max)
+	}
+	return nil
+}
+
+// enter records a non-tail function call. Pair each successful call
+// with leave.
+func (s *session) enter() error {
+	if max := s.limits.MaxDepth; max > 0 && s.depth >= max {
+		return quotaErr(QuotaDepth, int64(max))
+	}
+	s.depth++
+	return nil
+}
+
+func (s *session) leave() {
+	s.depth--
+}
+
+// checkSize verifies a value produced by a builtin is within limits.
+func (s *session) checkSize(e Expression) error {
+	switch e.tag {
+	case ExpList:
+		if max := s.limits.MaxListLength; max > 0 && len(e.list) > max {
+			return quotaErr(QuotaListLength, int64(max))
+		}
+	case ExpString:
+		if max := s.limits.MaxStringSize; max > 0 && len(e.string) > max {
+			return quotaErr(QuotaStringSize, int64(max))
+		}
+	}
+	return nil
+}
+
+// openFile reserves a file handle, returning an error if too many are
+// already open.
+func (s *session) openFile() error {
+	if max := s.limits.MaxOpenFiles; max > 0 && s.openFiles >= max {
+		return quotaErr(QuotaOpenFiles, int64(max))
+	}
+	s.openFiles++
+	return nil
+}
+
+func (s *session) closeFile() {
+	s.openFiles--
+}
@@ -20,17 +20,28 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"unicode"
 )
 
 // Reader is a container for dolling out expressions.
 type Reader struct {
 	buffer []rune
+	marks  []mark // where each rune in the buffer was read from
+	next   mark   // where the next appended rune will be read from
+	quote  string // the quote of a string left open by the last append
+}
+
+// form is the source of one top level expression.
+type form struct {
+	text  string
+	start mark
 }
 
 // NewReader returns a new instances of a reader
 func NewReader(forms ...string) *Reader {
 	r := &Reader{
 		buffer: make([]rune, 0),
+		marks:  make([]mark, 0),
 	}
 
 	for _, f := range forms {
@@ -49,20 +60,75 @@ func (reader *Reader) String() string {
 func (reader *Reader) IsBalanced() bool {
 	opens := 0
 	closes := 0
-	for _, c := range reader.buffer {
-		switch c {
-		case '(':
+	for i := 0; i < len(reader.buffer); i++ {
+		switch c := reader.buffer[i]; {
+		case c == '"':
+			if i = stringEnd(reader.buffer, i); i < 0 {
+				return false
+			}
+		case isOpener(c):
 			opens = opens + 1
-		case ')':
+		case isCloser(c):
 			closes = closes + 1
 		}
 	}
 	return opens == closes
 }
 
-// Append appends new data to the reader.
+// stringEnd returns the index of the last rune of the string starting
+// at runes[i], or -1 if the string doesn't end.
+func stringEnd(runes []rune, i int) int {
+	quote := openQuote(runes[i:])
+	n := closeQuote(runes[i+len(quote):], quote)
+	if n < 0 {
+		return -1
+	}
+	return i + len(quote) + n - 1
+}
+
+func isOpener(c rune) bool {
+	return c == '(' || c == '[' || c == '{'
+}
+
+func isCloser(c rune) bool {
+	return c == ')' || c == ']' || c == '}'
+}
+
+// Append appends new data to the reader, continuing the source
+// appended before it.
 func (reader *Reader) Append(line string) {
-	reader.buffer = append(reader.buffer, []rune(stripComments(line))...)
+	name := ""
+	if reader.next.src != nil {
+		name = reader.next.src.name
+	}
+	reader.AppendSource(name, line)
+}
+
+// AppendSource appends text read from the named file, so errors in it
+// report where they occurred. Appending more text under the same name
+// continues its line numbering.
+func (reader *Reader) AppendSource(name, text string) {
+	at := reader.next
+	if at.src == nil || at.src.name != name {
+		at = mark{line: 1, col: 1}
+	}
+	at.src = &source{name: name, text: text, first: at.line}
+
+	var stripped string
+	stripped, reader.quote = stripComments(text, reader.quote)
+
+	for _, c := range stripped {
+		reader.buffer = append(reader.buffer, c)
+		reader.marks = append(reader.marks, at)
+		if c == '\n' {
+			at.line++
+			at.col = 1
+		} else {
+			at.col++
+		}
+	}
+
+	reader.next = at
 }
 
 // ErrEOF means there's nothing left to read
@@ -70,88 +136,135 @@ var ErrEOF = errors.New("EOF")
 
 // GetForms returns all the available forms in the reader buffer.
 func (reader *Reader) GetForms() ([]string, error) {
-	forms := make([]string, 0)
+	forms, err := reader.readForms()
+	if err != nil {
+		return []string{}, err
+	}
+
+	texts := make([]string, 0, len(forms))
+	for _, f := range forms {
+		texts = append(texts, f.text)
+	}
+	return texts, nil
+}
+
+func (reader *Reader) readForms() ([]form, error) {
+	forms := make([]form, 0)
 
 	for {
-		form, err := reader.GetNextForm()
+		f, err := reader.nextForm()
 		if err == ErrEOF {
 			return forms, nil
 		} else if err != nil {
-			return []string{}, err
+			return []form{}, err
 		}
 
-		if form == "" {
+		if f.text == "" {
 			break
 		}
 
-		forms = append(forms, form)
+		forms = append(forms, f)
 	}
 	return forms, nil
 }
 
 // GetNextForm returns the next available expression from the buffer.
 func (reader *Reader) GetNextForm() (string, error) {
+	f, err := reader.nextForm()
+	return f.text, err
+}
+
+func (reader *Reader) nextForm() (form, error) {
 
 	if len(reader.buffer) == 0 {
-		return "", ErrEOF
+		return form{}, ErrEOF
 	}
 
-	form := make([]rune, 0)
-
 	opens := 0
 	closes := 0
+	length := 0
 
-	for _, c := range reader.buffer {
-		if c == '(' {
+	for i := 0; i < len(reader.buffer); i++ {
+		switch c := reader.buffer[i]; {
+		case c == '"':
+			// An unterminated string takes up the rest of the buffer.
+			if i = stringEnd(reader.buffer, i); i < 0 {
+				i = len(reader.buffer) - 1
+			}
+		case isOpener(c):
 			opens = opens + 1
-		} else if c == ')' {
+		case isCloser(c):
 			closes = closes + 1
 		}
 
-		form = append(form, c)
+		length = i + 1
 
 		if opens > 0 && (opens == closes) {
 			break
 		}
 	}
 
-	reader.buffer = reader.buffer[len(form):]
+	text := append([]rune{}, reader.buffer[:length]...)
 
-	if opens != closes {
-		return string(form), errors.New("incomplete form (missing parens)")
+	start, end := reader.marks[0], reader.marks[len(text)-1]
+	for i := range text {
+		if !unicode.IsSpace(text[i]) {
+			start = reader.marks[i]
+			break
+		}
+	}
+	for i := len(text) - 1; i >= 0; i-- {
+		if !unicode.IsSpace(text[i]) {
+			end = reader.marks[i]
+			break
+		}
 	}
-	return strings.TrimSpace(string(form)), nil
-}
-
-func stripComments(forms string) string {
-	fixed := make([]string, 0)
-	lines := strings.Split(forms, "\n")
-
-	inString := false
 
-	for _, l := range lines {
+	reader.buffer = reader.buffer[len(text):]
+	reader.marks = reader.marks[len(text):]
 
-		resolved := false
+	if opens != closes {
+		return form{string(text), start}, &Error{
+			Message: "incomplete form (missing parens)",
+			Span:    *newSpan(start, end),
+		}
+	}
+	return form{strings.TrimSpace(string(text)), start}, nil
+}
 
-		for pos, c := range l {
-			if c == '"' {
-				inString = !inString
-				continue
-			}
+// stripComments removes comments from text, keeping line breaks so
+// positions still match the source. quote is the quote of a string
+// left open by the text before it, if any. It also returns the quote
+// of a string the text leaves open.
+func stripComments(text, quote string) (string, string) {
+	runes := []rune(text)
+	fixed := make([]rune, 0, len(runes))
 
-			if c == ';' && !inString {
-				if pos != 0 {
-					fixed = append(fixed, l[:pos])
-				}
-				resolved = true
-				break
+	for i := 0; i < len(runes); {
+		if quote != "" {
+			n := closeQuote(runes[i:], quote)
+			if n < 0 {
+				return string(append(fixed, runes[i:]...)), quote
 			}
+			fixed = append(fixed, runes[i:i+n]...)
+			i, quote = i+n, ""
+			continue
 		}
 
-		if !resolved {
-			fixed = append(fixed, l)
+		switch c := runes[i]; c {
+		case '"':
+			quote = openQuote(runes[i:])
+			fixed = append(fixed, runes[i:i+len(quote)]...)
+			i += len(quote)
+		case ';':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		default:
+			fixed = append(fixed, c)
+			i++
 		}
 	}
 
-	return strings.Join(fixed, "\n")
+	return string(fixed), quote
 }
@@ -0,0 +1,194 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Capability is a group of builtins an interpreter may be granted.
+// Combine them with | to build a custom profile.
+type Capability uint
+
+// Capabilities
+const (
+	CapFileRead Capability = 1 << iota // open!, read-file, files, cd! …
+	CapExec                            // exec!, exec!!, shell!
+	CapExit                            // exit!
+	CapEnv                             // env, environment
+)
+
+// Named capability profiles
+const (
+	Pure       Capability = 0
+	ReadOnlyFS            = CapFileRead
+	Full                  = CapFileRead | CapExec | CapExit | CapEnv
+)
+
+func (c Capability) String() string {
+	names := []struct {
+		cap  Capability
+		name string
+	}{
+		{CapFileRead, "file-read"},
+		{CapExec, "exec"},
+		{CapExit, "exit"},
+		{CapEnv, "env"},
+	}
+
+	granted := make([]string, 0)
+	for _, n := range names {
+		if c&n.cap != 0 {
+			granted = append(granted, n.name)
+		}
+	}
+
+	if len(granted) == 0 {
+		return "pure"
+	}
+	return strings.Join(granted, "|")
+}
+
+// Allows is true if c grants every capability in required. Pure
+// requires nothing, so it's always allowed.
+func (c Capability) Allows(required Capability) bool {
+	return c&required == required
+}
+
+// WithCapabilities sets the builtin groups available to scripts. The
+// default is Full.
+func WithCapabilities(caps Capability) Option {
+	return func(c *config) {
+		c.capabilities = caps
+	}
+}
+
+// WithAllowedExecutables restricts exec!, exec!! and shell! to the
+// named commands, matched exactly as written in the script.
+func WithAllowedExecutables(names ...string) Option {
+	return func(c *config) {
+		c.executables = append(c.executables, names...)
+	}
+}
+
+// WithFileRoots restricts file builtins to paths inside the given
+// directories. Relative roots are taken from the interpreter's initial
+// working directory.
+func WithFileRoots(dirs ...string) Option {
+	return func(c *config) {
+		c.fileRoots = append(c.fileRoots, dirs...)
+	}
+}
+
+// PermissionError is returned when a script calls a builtin, or uses
+// a command or path, the interpreter's sandbox doesn't allow.
+type PermissionError struct {
+	Builtin string // the builtin called
+	Subject string // the command or path denied, if any
+	Reason  string
+}
+
+func (e *PermissionError) Error() string {
+	if e.Subject == "" {
+		return fmt.Sprintf("permission denied: '%v' %v", e.Builtin, e.Reason)
+	}
+	return fmt.Sprintf("permission denied: '%v' on '%v': %v", e.Builtin, e.Subject, e.Reason)
+}
+
+// deniedBuiltin stands in for a builtin whose capability wasn't
+// granted, so calling it explains why rather than reporting an
+// unknown name.
+func deniedBuiltin(name string, c Capability) PrimitiveFunc {
+	return func(args []Expression) (Expression, error) {
+		return NilExpression, &PermissionError{
+			Builtin: name,
+			Reason:  fmt.Sprintf("requires the '%v' capability", c),
+		}
+	}
+}
+
+func (s *session) checkExecutable(builtin, cmd string) error {
+	if len(s.executables) == 0 {
+		return nil
+	}
+
+	for _, name := range s.executables {
+		if name == cmd {
+			return nil
+		}
+	}
+
+	return &PermissionError{Builtin: builtin, Subject: cmd, Reason: "executable not allowed"}
+}
+
+// checkPath resolves a script supplied path against the interpreter's
+// working directory, returning an error if it's outside the allowed
+// roots.
+func (s *session) checkPath(builtin, path string) (string, error) {
+	abs := s.absPath(path)
+
+	if len(s.fileRoots) == 0 {
+		return abs, nil
+	}
+
+	target, err := resolvePath(abs)
+	if err != nil {
+		return "", err
+	}
+
+	for _, root := range s.fileRoots {
+		dir, err := resolvePath(root)
+		if err != nil {
+			return "", err
+		}
+		if isWithin(dir, target) {
+			return abs, nil
+		}
+	}
+
+	return "", &PermissionError{Builtin: builtin, Subject: path, Reason: "path outside allowed roots"}
+}
+
+// resolvePath returns an absolute path with symlinks evaluated as far
+// as the path exists, so links can't be used to escape a root.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	missing := ""
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, missing), nil
+		}
+		if dir == filepath.Dir(dir) {
+			return abs, nil
+		}
+		missing = filepath.Join(filepath.Base(dir), missing)
+	}
+}
+
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
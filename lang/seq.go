@@ -0,0 +1,94 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import "sync/atomic"
+
+// Lists made by append and prepend share an array with the list they
+// were made from, with room to grow at both ends. A list is a window
+// onto the array, and the array records how much of it is claimed.
+// Appending to a list that ends where the claimed part does claims the
+// next slot, and the new list is a window one slot longer, so building
+// a list an element at a time costs O(1) per element. Appending to any
+// other list, which would overwrite an element some list can see,
+// copies it into a new array. Prepending works the same way at the
+// front. Slots are claimed atomically, as lists can be shared by
+// interpreters running at the same time.
+
+type listBuffer struct {
+	items []Expression
+	front int64 // the first claimed slot
+	back  int64 // one past the last claimed slot
+}
+
+// appendItem returns the list with item added at the end.
+func appendItem(list, item Expression) Expression {
+	elems := list.list
+	n := len(elems)
+
+	if b := list.buffer; b != nil && n > 0 {
+		back := atomic.LoadInt64(&b.back)
+		if int(back) < len(b.items) && &b.items[back-1] == &elems[n-1] &&
+			atomic.CompareAndSwapInt64(&b.back, back, back+1) {
+			b.items[back] = item
+			start := int(back) - n
+			return sharedList(b, b.items[start:back+1:back+1])
+		}
+	}
+
+	b := &listBuffer{items: make([]Expression, 2*n+1)}
+	copy(b.items, elems)
+	b.items[n] = item
+	b.back = int64(n + 1)
+	return sharedList(b, b.items[:n+1:n+1])
+}
+
+// prependItem returns the list with item added at the front.
+func prependItem(item, list Expression) Expression {
+	elems := list.list
+	n := len(elems)
+
+	if b := list.buffer; b != nil && n > 0 {
+		front := atomic.LoadInt64(&b.front)
+		if front > 0 && &b.items[front] == &elems[0] &&
+			atomic.CompareAndSwapInt64(&b.front, front, front-1) {
+			b.items[front-1] = item
+			end := int(front) + n
+			return sharedList(b, b.items[front-1:end:end])
+		}
+	}
+
+	size := 2*n + 1
+	b := &listBuffer{items: make([]Expression, size)}
+	copy(b.items[size-n:], elems)
+	b.items[size-n-1] = item
+	b.front = int64(size - n - 1)
+	b.back = int64(size)
+	return sharedList(b, b.items[size-n-1:])
+}
+
+// tailOf returns the list without its first element, which it must
+// have.
+func tailOf(list Expression) Expression {
+	return sharedList(list.buffer, list.list[1:])
+}
+
+// sharedList returns a list of elems, a window onto b. Its capacity is
+// its length, so appending to it with Go's append copies it.
+func sharedList(b *listBuffer, elems []Expression) Expression {
+	return Expression{tag: ExpList, list: elems, buffer: b, cached: newHashCell()}
+}
@@ -0,0 +1,133 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// session is the per-interpreter state shared by the evaluator and
+// the builtins bound to it.
+type session struct {
+	ctx          context.Context
+	limits       Limits
+	steps        int64
+	running      int // top-level evaluations in progress
+	depth        int
+	openFiles    int
+	capabilities Capability
+	executables  []string
+	fileRoots    []string
+	stdin        io.Reader
+	stdout       io.Writer
+	stderr       io.Writer
+	dir          string // working directory for cd!, files, exec! …
+	loadPath     []string
+	required     map[string]bool // namespaces loaded by require
+}
+
+func newSession(c *config) *session {
+	dir := c.dir
+	if dir == "" {
+		if wd, err := os.Getwd(); err == nil {
+			dir = wd
+		}
+	}
+
+	dir, _ = filepath.Abs(dir)
+
+	s := &session{
+		ctx:          context.Background(),
+		limits:       c.limits,
+		capabilities: c.capabilities,
+		executables:  c.executables,
+		stdin:        c.stdin,
+		stdout:       c.stdout,
+		stderr:       c.stderr,
+		dir:          dir,
+		required:     make(map[string]bool),
+	}
+
+	for _, root := range c.fileRoots {
+		s.fileRoots = append(s.fileRoots, s.absPath(root))
+	}
+
+	for _, dir := range c.loadPath {
+		s.loadPath = append(s.loadPath, s.absPath(dir))
+	}
+
+	return s
+}
+
+// absPath resolves path against the session's working directory
+// rather than the process's, so interpreters can't disturb each other.
+func (s *session) absPath(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(s.dir, path)
+}
+
+// withContext installs ctx for the duration of an evaluation. Call
+// the returned function to restore the previous context.
+func (s *session) withContext(ctx context.Context) func() {
+	prev := s.ctx
+	s.ctx = ctx
+	return func() {
+		s.ctx = prev
+	}
+}
+
+// checkContext returns a *CancelledError if evaluation should stop.
+func (s *session) checkContext() error {
+	if err := s.ctx.Err(); err != nil {
+		return &CancelledError{Err: err}
+	}
+	return nil
+}
+
+// invokePrimitive calls a builtin with evaluated args, subject to the
+// session's context and size quotas.
+func (s *session) invokePrimitive(fn Expression, args []Expression) (Expression, error) {
+	if err := s.checkContext(); err != nil {
+		return NilExpression, err
+	}
+
+	ret, err := fn.InvokePrimitive(args)
+	if err != nil {
+		return ret, err
+	}
+	return ret, s.checkSize(ret)
+}
+
+// CancelledError is returned when an evaluation is stopped because
+// its context was cancelled or its deadline passed.
+type CancelledError struct {
+	Err error // context.Canceled or context.DeadlineExceeded
+}
+
+func (e *CancelledError) Error() string {
+	return "evaluation cancelled: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying context error.
+func (e *CancelledError) Unwrap() error {
+	return e.Err
+}
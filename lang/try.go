@@ -0,0 +1,101 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lang
+
+import "fmt"
+
+// (try body… (catch e handler…) (finally cleanup…))
+//
+// Any error raised evaluating the body, whether thrown or returned by
+// a builtin, is bound to `e` as an error value and the handler's value
+// returned instead. The cleanup is evaluated however the body or the
+// handler finished, and its value ignored. Cancellation and exceeded
+// quotas can't be caught, and skip cleanups.
+
+const trySig = "(try body… (catch e handler…) (finally cleanup…))"
+
+type tryForm struct {
+	body    Expression
+	catch   *Expression // the name bound to the error, if there's a catch
+	handler Expression
+	cleanup *Expression
+}
+
+func isClause(e Expression, name string) bool {
+	return e.IsList() && len(e.list) > 0 && e.list[0].IsSymbol() && e.list[0].symbol == name
+}
+
+func parseTry(args Expression) (tryForm, error) {
+	forms := args.list
+	t := tryForm{}
+
+	i := 0
+	for ; i < len(forms) && !isClause(forms[i], "catch") && !isClause(forms[i], "finally"); i++ {
+	}
+	t.body = WrapImplicitDo(forms[:i])
+
+	if i < len(forms) && isClause(forms[i], "catch") {
+		clause := forms[i].list
+		if len(clause) < 2 || !clause[1].IsSymbol() {
+			return t, fmt.Errorf("%v «-- catch must name the error", trySig)
+		}
+		t.catch = &clause[1]
+		t.handler = WrapImplicitDo(clause[2:])
+		i++
+	}
+
+	if i < len(forms) && isClause(forms[i], "finally") {
+		cleanup := WrapImplicitDo(forms[i].list[1:])
+		t.cleanup = &cleanup
+		i++
+	}
+
+	if i < len(forms) {
+		return t, fmt.Errorf("%v «-- '%v' must come last", trySig, forms[i].Head())
+	}
+	return t, nil
+}
+
+func evalTry(interp Interpreter, env *Environment, args Expression) (Expression, error) {
+	t, err := parseTry(args)
+	if err != nil {
+		return NilExpression, err
+	}
+
+	result, err := interp.Evaluate(env, t.body)
+
+	if err != nil && !isCatchable(err) {
+		return NilExpression, err
+	}
+
+	if err != nil && t.catch != nil {
+		handlerEnv, frame := env.extend()
+		frame.bind(t.catch.symbol, caught(err))
+		result, err = interp.Evaluate(handlerEnv, t.handler)
+	}
+
+	if t.cleanup != nil {
+		if _, cleanupErr := interp.Evaluate(env, *t.cleanup); cleanupErr != nil {
+			return NilExpression, cleanupErr
+		}
+	}
+
+	if err != nil {
+		return NilExpression, err
+	}
+	return result, nil
+}
@@ -0,0 +1,125 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+// Compare the interpreters with:
+//
+//    go test -run NONE -bench Evaluators ./test
+//
+// and time comparing values and using them as keys with:
+//
+//    go test -run NONE -bench Hashing ./test
+
+import (
+	"testing"
+
+	haki "github.com/zentrope/haki/lang"
+)
+
+const benchDefs = `
+(defun fib (n)
+  (if (< n 2)
+    n
+    (+ (fib (- n 1)) (fib (- n 2)))))
+
+(defun count-to (n)
+  (let next (i 0)
+    (if (< i n) (next (inc i)) i)))
+
+(defun adder (n)
+  (fn (x) (+ x n)))
+
+(defun sum-pairs (pairs)
+  (let walk (ps pairs total 0)
+    (if (= 0 (count ps))
+      total
+      (let (((a b) & more) ps)
+        (walk more (+ total a b))))))
+`
+
+var benchPrograms = []struct {
+	name    string
+	program string
+}{
+	{"fib", `(fib 15)`},
+	{"tail-loop", `(count-to 5000)`},
+	{"closures", `(reduce + 0 (map (adder 3) (filter odd? (range 200))))`},
+	{"destructure", `(sum-pairs (map (fn (i) (list i (* 2 i))) (range 200)))`},
+	{"let", `(let (a 1 b (+ a 1) c (* b 3)) (list a b c))`},
+	{"append", `(count (reduce (fn (l i) (append l i)) '() (range 2000)))`},
+	{"hset", `(count (reduce (fn (m i) (hset m i i)) (hmap) (range 2000)))`},
+}
+
+const hashDefs = `
+(def xs (range 2000))
+(def ys (range 2000))
+
+(def by-id (reduce (fn (m i) (hset m (hmap :id i) i)) (hmap) (range 1000)))
+`
+
+var hashPrograms = []struct {
+	name    string
+	program string
+}{
+	{"equal-lists", `(= xs ys)`},
+	{"equal-maps", `(= (hmap :a xs :b ys) (hmap :b ys :a xs))`},
+	{"new-lists", `(= (range 500) (range 500))`},
+	{"list-keys", `(count (reduce (fn (m i) (hset m (list i i) i)) (hmap) (range 1000)))`},
+	{"map-key-lookup", `(hget by-id (hmap :id 500))`},
+	{"pipeline", `(reduce + 0 (map inc (filter odd? (range 2000))))`},
+}
+
+func BenchmarkEvaluators(b *testing.B) {
+	for _, p := range benchPrograms {
+		for _, e := range evaluators {
+			b.Run(p.name+"/"+e.name, func(b *testing.B) {
+				benchProgram(b, e.kind, benchDefs, p.program)
+			})
+		}
+	}
+}
+
+func BenchmarkHashing(b *testing.B) {
+	for _, p := range hashPrograms {
+		for _, e := range evaluators {
+			b.Run(p.name+"/"+e.name, func(b *testing.B) {
+				benchProgram(b, e.kind, hashDefs, p.program)
+			})
+		}
+	}
+}
+
+// benchProgram times running program in an interpreter of the given
+// kind, once defs have been run.
+func benchProgram(b *testing.B, kind haki.Type, defs, source string) {
+	interp := haki.NewInterpreter(kind)
+	if _, err := interp.Run(haki.NewReader(haki.Core, defs)); err != nil {
+		b.Fatal(err)
+	}
+
+	program, err := haki.Compile(source)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := interp.RunProgram(program); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
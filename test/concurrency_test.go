@@ -0,0 +1,182 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+// Run with `go test -race` (or `make test-race`) to check interpreters
+// share no mutable state.
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	haki "github.com/zentrope/haki/lang"
+)
+
+const isolationScript = `
+(def who (whoami))
+
+(defun tag (x)
+  (format "%v-%v" who x))
+
+(cd! (hget (config) "dir"))
+
+(prn (cwd))
+(prn (map tag (filter odd? (range 6))))
+(prn (files "." "*.txt"))
+(prn (trim (third (exec! "pwd"))))
+`
+
+func runIsolated(id int, dir string) (string, error) {
+	var out bytes.Buffer
+
+	interp := haki.NewScriptInterpreter(haki.TCO, []string{}, haki.WithStdout(&out))
+
+	interp.Define("whoami", func(args []haki.Expression) (haki.Expression, error) {
+		return haki.NewIntExpr(int64(id)), nil
+	})
+
+	interp.Define("config", func(args []haki.Expression) (haki.Expression, error) {
+		return haki.FromGo(map[string]string{"dir": dir})
+	})
+
+	_, err := interp.Run(haki.NewReader(haki.Core, isolationScript))
+	return out.String(), err
+}
+
+func TestConcurrentInterpretersAreIsolated(t *testing.T) {
+	startDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 8
+
+	dirs := make([]string, workers)
+	for i := range dirs {
+		dir, err := ioutil.TempDir("", "haki")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		dir, _ = filepath.EvalSymlinks(dir)
+		name := filepath.Join(dir, fmt.Sprintf("%v.txt", i))
+		if err := ioutil.WriteFile(name, []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		dirs[i] = dir
+	}
+
+	var wg sync.WaitGroup
+	outputs := make([]string, workers)
+	errs := make([]error, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outputs[i], errs[i] = runIsolated(i, dirs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < workers; i++ {
+		if errs[i] != nil {
+			t.Errorf("worker %v: %v", i, errs[i])
+			continue
+		}
+
+		expected := strings.Join([]string{
+			dirs[i],
+			fmt.Sprintf(`("%v-1" "%v-3" "%v-5")`, i, i, i),
+			fmt.Sprintf(`("%v.txt")`, i),
+			dirs[i],
+		}, "\n") + "\n"
+
+		if outputs[i] != expected {
+			t.Errorf("worker %v: expected:\n%v\ngot:\n%v", i, expected, outputs[i])
+		}
+	}
+
+	if wd, _ := os.Getwd(); wd != startDir {
+		t.Errorf("Expected process directory to stay '%v', got '%v'.", startDir, wd)
+	}
+}
+
+const ruleScript = `
+(defun score (x)
+  (reduce + 0 (map inc (filter even? (range x)))))
+
+(prn (score (who)))
+(score (who))
+`
+
+func TestProgramsAndSnapshotsAreShareable(t *testing.T) {
+	program, err := haki.Compile(ruleScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, err := haki.CoreSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 8
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+
+			var out bytes.Buffer
+			interp := haki.NewInterpreter(haki.TCO, haki.WithSnapshot(core), haki.WithStdout(&out))
+			interp.Define("who", func(args []haki.Expression) (haki.Expression, error) {
+				return haki.NewIntExpr(int64(n)), nil
+			})
+
+			// the sum of (x + 1) for each even x below n
+			expected := int64(0)
+			for x := 0; x < n; x += 2 {
+				expected += int64(x + 1)
+			}
+
+			for run := 0; run < 3; run++ {
+				out.Reset()
+				rc, err := interp.RunProgram(program)
+				if err != nil {
+					t.Errorf("worker %v: %v", n, err)
+					return
+				}
+				if !rc.IsEqual(expected) {
+					t.Errorf("worker %v: expected %v, got '%v'.", n, expected, rc)
+				}
+				if out.String() != fmt.Sprintf("%v\n", expected) {
+					t.Errorf("worker %v: expected output %v, got '%v'.", n, expected, out.String())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
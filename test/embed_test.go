@@ -0,0 +1,680 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	haki "github.com/zentrope/haki/lang"
+)
+
+func shout(args []haki.Expression) (haki.Expression, error) {
+	s := args[0].Value().(string)
+	return haki.NewStringExpr(strings.ToUpper(s) + "!"), nil
+}
+
+func TestDefineHostFunctions(t *testing.T) {
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		interp := haki.NewInterpreter(kind)
+
+		err := interp.DefineBuiltin(haki.Builtin{
+			Name:    "shout",
+			Doc:     "Upper-cases a string.",
+			MinArgs: 1,
+			MaxArgs: 1,
+			Fn:      shout,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rc, err := interp.Execute(`(shout "hello")`)
+		if err != nil {
+			t.Error(err)
+		} else if !rc.IsEqual("HELLO!") {
+			t.Errorf("Expected 'HELLO!', got '%v'.", rc)
+		}
+
+		rc, err = interp.Execute(`(doc shout)`)
+		if err != nil {
+			t.Error(err)
+		} else if !rc.IsEqual("Upper-cases a string.") {
+			t.Errorf("Expected doc string, got '%v'.", rc)
+		}
+
+		if _, err := interp.Execute(`(shout "a" "b")`); err == nil {
+			t.Error("Expected an arity error for too many args.")
+		}
+	}
+}
+
+func TestDefineIsPerInterpreter(t *testing.T) {
+	with := haki.NewInterpreter(haki.TCO)
+	without := haki.NewInterpreter(haki.TCO)
+
+	if err := with.Define("shout", shout); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := with.Execute(`(shout "x")`); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := without.Execute(`(shout "x")`); err == nil {
+		t.Error("Expected 'shout' to be undefined in a second interpreter.")
+	}
+
+	if err := with.DefineBuiltin(haki.Builtin{Name: "bad", MinArgs: 2, MaxArgs: 1, Fn: shout}); err == nil {
+		t.Error("Expected an error for an invalid arity range.")
+	}
+}
+
+type server struct {
+	Host    string            `haki:"host"`
+	Port    int               `haki:"port"`
+	Tags    []string          `haki:"tags"`
+	Weight  float64           `haki:"weight"`
+	Enabled bool              `haki:"enabled"`
+	Labels  map[string]string `haki:"labels"`
+	Secret  string            `haki:"-"`
+}
+
+func TestCallScriptFunctions(t *testing.T) {
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		interp := haki.NewInterpreter(kind)
+
+		_, err := interp.Run(haki.NewReader(haki.Core, `
+(def events 0)
+(defun on-event (name n)
+  (def events (+ events n))
+  (format "%v:%v" name events))
+(def twice (fn (x) (* x 2)))
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rc, err := interp.Call("on-event", haki.NewStringExpr("click"), haki.NewIntExpr(3))
+		if err != nil {
+			t.Error(err)
+		} else if !rc.IsEqual("click:3") {
+			t.Errorf("Expected 'click:3', got '%v'.", rc)
+		}
+
+		twice, err := interp.Execute("twice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rc, err = interp.Apply(twice, []haki.Expression{haki.NewIntExpr(21)})
+		if err != nil {
+			t.Error(err)
+		} else if !rc.IsEqual(int64(42)) {
+			t.Errorf("Expected 42, got '%v'.", rc)
+		}
+
+		rc, err = interp.Call("+", haki.NewIntExpr(1), haki.NewIntExpr(2))
+		if err != nil {
+			t.Error(err)
+		} else if !rc.IsEqual(int64(3)) {
+			t.Errorf("Expected 3, got '%v'.", rc)
+		}
+
+		if _, err := interp.Call("on-event", haki.NewStringExpr("click")); err == nil {
+			t.Error("Expected an arity error.")
+		}
+
+		if _, err := interp.Call("no-such-fn"); err == nil {
+			t.Error("Expected an error calling an undefined function.")
+		}
+
+		if _, err := interp.Call("events"); err == nil {
+			t.Error("Expected an error calling a non-function.")
+		}
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	in := server{
+		Host:    "localhost",
+		Port:    8080,
+		Tags:    []string{"a", "b"},
+		Weight:  0.5,
+		Enabled: true,
+		Labels:  map[string]string{"env": "dev"},
+		Secret:  "hidden",
+	}
+
+	interp := haki.NewInterpreter(haki.TCO)
+	interp.Define("server", func(args []haki.Expression) (haki.Expression, error) {
+		return haki.FromGo(in)
+	})
+
+	rc, err := interp.Execute(`(hset (server) 'port 9090 'secret "leak")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out server
+	if err := haki.ToGo(rc, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Host != "localhost" || out.Port != 9090 || out.Weight != 0.5 || !out.Enabled {
+		t.Errorf("Unexpected scalar fields: %+v", out)
+	}
+	if len(out.Tags) != 2 || out.Tags[1] != "b" || out.Labels["env"] != "dev" {
+		t.Errorf("Unexpected composite fields: %+v", out)
+	}
+	if out.Secret != "" {
+		t.Errorf("Expected skipped field to stay empty, got '%v'.", out.Secret)
+	}
+
+	var generic interface{}
+	if err := haki.ToGo(rc, &generic); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := generic.(map[string]interface{}); !ok || m["host"] != "localhost" {
+		t.Errorf("Expected a generic map, got %#v", generic)
+	}
+
+	rc, err = interp.Execute(`{:host "example.com" :tags [:a "b"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var literal server
+	if err := haki.ToGo(rc, &literal); err != nil {
+		t.Fatal(err)
+	}
+	if literal.Host != "example.com" || len(literal.Tags) != 2 || literal.Tags[0] != "a" {
+		t.Errorf("Unexpected fields from a literal: %+v", literal)
+	}
+
+	var n int8
+	if err := haki.ToGo(haki.NewIntExpr(1000), &n); err == nil {
+		t.Error("Expected an overflow error converting 1000 to int8.")
+	}
+}
+
+type Endpoint struct {
+	Path string `haki:"path"`
+}
+
+type route struct {
+	*Endpoint
+	Method string `haki:"method"`
+}
+
+func TestMarshalEmbeddedStructs(t *testing.T) {
+	interp := haki.NewInterpreter(haki.TCO)
+
+	rc, err := haki.FromGo(route{&Endpoint{"/a"}, "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	interp.Define("rc", func(args []haki.Expression) (haki.Expression, error) {
+		return rc, nil
+	})
+	if path, err := interp.Execute(`(hget (rc) 'path)`); err != nil || !path.IsEqual("/a") {
+		t.Errorf("Expected promoted field '/a', got '%v' (%v).", path, err)
+	}
+
+	var out route
+	if err := haki.ToGo(rc, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Endpoint == nil || out.Path != "/a" || out.Method != "GET" {
+		t.Errorf("Unexpected fields: %+v", out)
+	}
+
+	rc, err = haki.FromGo(route{Method: "PUT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := interp.Execute(`(count (rc))`); err != nil || !n.IsEqual(int64(1)) {
+		t.Errorf("Expected a nil embed's fields to be skipped, got %v entries (%v).", n, err)
+	}
+}
+
+func TestRunContextCancelsRunawayScript(t *testing.T) {
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		interp := haki.NewInterpreter(kind)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+
+		reader := haki.NewReader(`(defun spin (n) (spin (+ n 1))) (spin 0)`)
+		_, err := interp.RunContext(ctx, reader)
+		cancel()
+
+		var ce *haki.CancelledError
+		if !errors.As(err, &ce) {
+			t.Errorf("Expected a *CancelledError, got '%v'.", err)
+		}
+	}
+}
+
+func TestRunContextKillsProcesses(t *testing.T) {
+	interp := haki.NewInterpreter(haki.TCO)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := interp.RunContext(ctx, haki.NewReader(`(exec! "sleep" "10")`))
+
+	var ce *haki.CancelledError
+	if !errors.As(err, &ce) {
+		t.Errorf("Expected a *CancelledError, got '%v'.", err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Error("Expected the sub-process to be killed on cancellation.")
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	table := []struct {
+		quota  string
+		limits haki.Limits
+		form   string
+	}{
+		{haki.QuotaSteps, haki.Limits{MaxSteps: 1000}, `(defun spin (n) (spin (+ n 1))) (spin 0)`},
+		{haki.QuotaDepth, haki.Limits{MaxDepth: 50}, `(defun deep (n) (+ 1 (deep n))) (deep 0)`},
+		{haki.QuotaListLength, haki.Limits{MaxListLength: 5}, `(range 10)`},
+		{haki.QuotaStringSize, haki.Limits{MaxStringSize: 5}, `(upper-case "abcdefgh")`},
+		{haki.QuotaOpenFiles, haki.Limits{MaxOpenFiles: 1}, `(open! "/dev/null") (open! "/dev/null")`},
+	}
+
+	for _, row := range table {
+		interp := haki.NewInterpreter(haki.TCO, haki.WithLimits(row.limits))
+		_, err := interp.Run(haki.NewReader(haki.Core, row.form))
+
+		var qe *haki.QuotaExceededError
+		if !errors.As(err, &qe) {
+			t.Errorf("%v: expected a *QuotaExceededError, got '%v'.", row.form, err)
+			continue
+		}
+		if qe.Quota != row.quota {
+			t.Errorf("%v: expected quota '%v', got '%v'.", row.form, row.quota, qe.Quota)
+		}
+	}
+}
+
+func TestDepthQuotaCountsCalls(t *testing.T) {
+	const src = `
+(defun down (n) (if (= n 0) 0 (+ 1 (down (- n 1)))))
+(defun spin (n) (if (= n 0) 0 (spin (- n 1))))`
+
+	table := []struct {
+		limits haki.Limits
+		form   string
+		ok     bool
+	}{
+		{haki.Limits{MaxDepth: 50}, `(down 49)`, true},
+		{haki.Limits{MaxDepth: 50}, `(down 50)`, false},
+		{haki.Limits{MaxDepth: 50}, `(do (down 49))`, true},
+		{haki.Limits{}, `(down 5000)`, true},
+		{haki.Limits{}, `(count (map inc (range 5000)))`, true},
+	}
+
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		for _, row := range table {
+			interp := haki.NewInterpreter(kind, haki.WithLimits(row.limits))
+			_, err := interp.Run(haki.NewReader(haki.Core, src, row.form))
+
+			var qe *haki.QuotaExceededError
+			switch {
+			case row.ok && err != nil:
+				t.Errorf("%v: %v: unexpected error '%v'.", kind, row.form, err)
+			case !row.ok && !errors.As(err, &qe):
+				t.Errorf("%v: %v: expected a *QuotaExceededError, got '%v'.", kind, row.form, err)
+			}
+		}
+	}
+
+	// Tail calls don't go any deeper in the interpreters that have them.
+	for _, kind := range []haki.Type{haki.TCO, haki.VM} {
+		interp := haki.NewInterpreter(kind, haki.WithLimits(haki.Limits{MaxDepth: 5}))
+		if _, err := interp.Run(haki.NewReader(haki.Core, src, `(spin 1000)`)); err != nil {
+			t.Errorf("%v: unexpected error '%v'.", kind, err)
+		}
+	}
+}
+
+func TestStepQuotaIsPerCall(t *testing.T) {
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		interp := haki.NewInterpreter(kind, haki.WithLimits(haki.Limits{MaxSteps: 100}))
+		_, err := interp.Run(haki.NewReader(`
+(defun inc2 (n) (+ n 2))
+(defun spin-to (n i) (if (< i n) (spin-to n (+ i 1)) i))
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 200; i++ {
+			if _, err := interp.Call("inc2", haki.NewIntExpr(int64(i))); err != nil {
+				t.Fatalf("call %v: %v", i, err)
+			}
+		}
+
+		var qe *haki.QuotaExceededError
+		_, err = interp.Execute(`(spin-to 1000 0)`)
+		if !errors.As(err, &qe) {
+			t.Errorf("%v: expected a single call over the limit to fail, got '%v'.", kind, err)
+		}
+	}
+}
+
+func TestClosingFilesReleasesQuota(t *testing.T) {
+	interp := haki.NewInterpreter(haki.TCO, haki.WithLimits(haki.Limits{MaxOpenFiles: 1}))
+	form := `(close! (open! "/dev/null")) (close! (open! "/dev/null"))`
+	if _, err := interp.Run(haki.NewReader(form)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCapabilitiesAllow(t *testing.T) {
+	table := []struct {
+		granted  haki.Capability
+		required haki.Capability
+		allowed  bool
+	}{
+		{haki.Pure, haki.Pure, true},
+		{haki.Pure, haki.CapExec, false},
+		{haki.CapExec, haki.CapExec | haki.CapEnv, false},
+		{haki.CapEnv, haki.CapExec | haki.CapEnv, false},
+		{haki.CapExec | haki.CapEnv, haki.CapExec | haki.CapEnv, true},
+		{haki.Full, haki.CapFileRead | haki.CapExec, true},
+	}
+
+	for _, row := range table {
+		if got := row.granted.Allows(row.required); got != row.allowed {
+			t.Errorf("(%v).Allows(%v): expected %v, got %v.", row.granted, row.required, row.allowed, got)
+		}
+	}
+}
+
+func TestSandboxProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inside := filepath.Join(dir, "inside.txt")
+	if err := ioutil.WriteFile(inside, []byte("ok"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		opts    []haki.Option
+		form    string
+		allowed bool
+	}{
+		{[]haki.Option{haki.WithCapabilities(haki.Pure)}, `(exec! "echo" "hi")`, false},
+		{[]haki.Option{haki.WithCapabilities(haki.Pure)}, `(env "HOME")`, false},
+		{[]haki.Option{haki.WithCapabilities(haki.Pure)}, `(+ 1 2)`, true},
+		{[]haki.Option{haki.WithCapabilities(haki.ReadOnlyFS)}, `(exec! "echo" "hi")`, false},
+		{[]haki.Option{haki.WithCapabilities(haki.ReadOnlyFS), haki.WithFileRoots(dir)},
+			fmt.Sprintf(`(read-file "%v")`, inside), true},
+		{[]haki.Option{haki.WithCapabilities(haki.ReadOnlyFS), haki.WithFileRoots(dir)},
+			`(read-file "/etc/hostname")`, false},
+		{[]haki.Option{haki.WithFileRoots(dir)}, `(files "/etc")`, false},
+		{[]haki.Option{haki.WithFileRoots(dir)}, fmt.Sprintf(`(open! "%v/../x")`, dir), false},
+		{[]haki.Option{haki.WithAllowedExecutables("echo")}, `(exec! "echo" "hi")`, true},
+		{[]haki.Option{haki.WithAllowedExecutables("echo")}, `(exec!! "ls")`, false},
+		{[]haki.Option{haki.WithCapabilities(haki.CapExec | haki.CapEnv)}, `(env "HOME")`, true},
+	}
+
+	for _, row := range table {
+		interp := haki.NewInterpreter(haki.TCO, row.opts...)
+		_, err := interp.Execute(row.form)
+
+		if row.allowed && err != nil {
+			t.Errorf("%v: expected success, got '%v'.", row.form, err)
+		}
+
+		if !row.allowed {
+			var pe *haki.PermissionError
+			if !errors.As(err, &pe) {
+				t.Errorf("%v: expected a *PermissionError, got '%v'.", row.form, err)
+			}
+		}
+	}
+}
+
+func TestRedirectedStreams(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	interp := haki.NewInterpreter(haki.TCO,
+		haki.WithStdin(strings.NewReader("first\nsecond\n")),
+		haki.WithStdout(&stdout),
+		haki.WithStderr(&stderr))
+
+	form := `
+(prn "line:" (read-line *stdin*))
+(prn (read-line *stdin*))
+(shell! "echo" "from child")
+(shell! "sh" "-c" "echo oops 1>&2")`
+
+	if _, err := interp.Run(haki.NewReader(form)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "line: first\nsecond\nfrom child\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected stdout %q, got %q.", expected, stdout.String())
+	}
+
+	if stderr.String() != "oops\n" {
+		t.Errorf("Expected stderr %q, got %q.", "oops\n", stderr.String())
+	}
+
+	stdout.Reset()
+	piped := haki.NewInterpreter(haki.TCO,
+		haki.WithStdin(strings.NewReader("piped\n")),
+		haki.WithStdout(&stdout))
+
+	if _, err := piped.Execute(`(shell! "cat")`); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "piped\n" {
+		t.Errorf("Expected shell! to read the interpreter's stdin, got %q.", stdout.String())
+	}
+}
+
+func TestSnapshotMatchesLoadingCore(t *testing.T) {
+	core, err := haki.CoreSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		interp := haki.NewInterpreter(kind, haki.WithSnapshot(core))
+
+		rc, err := interp.Execute(`(map inc (filter odd? (range 5)))`)
+		if err != nil {
+			t.Error(err)
+		} else if !rc.IsEqual([]int64{2, 4}) {
+			t.Errorf("Expected (2 4), got '%v'.", rc)
+		}
+
+		// Redefining a core function affects only this interpreter.
+		if _, err := interp.Execute(`(defun inc (x) (+ x 100))`); err != nil {
+			t.Fatal(err)
+		}
+
+		other := haki.NewInterpreter(kind, haki.WithSnapshot(core))
+		rc, err = other.Execute(`(inc 1)`)
+		if err != nil {
+			t.Error(err)
+		} else if !rc.IsEqual(int64(2)) {
+			t.Errorf("Expected 2, got '%v'.", rc)
+		}
+	}
+}
+
+func TestRunProgramAgain(t *testing.T) {
+	program, err := haki.Compile(`(defmacro twice (x) (list '* 2 x))`, `(list (f 2) (twice 2))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		interp := haki.NewInterpreter(kind)
+		if _, err := interp.Execute(`(defun f (x) (+ x 1))`); err != nil {
+			t.Fatal(err)
+		}
+
+		rc, err := interp.RunProgram(program)
+		if err != nil {
+			t.Fatal(err)
+		} else if !rc.IsEqual([]int64{3, 4}) {
+			t.Errorf("%v: expected (3 4), got '%v'.", kind, rc)
+		}
+
+		// Runs again see definitions made since.
+		if _, err := interp.Execute(`(defun f (x) (+ x 10))`); err != nil {
+			t.Fatal(err)
+		}
+		rc, err = interp.RunProgram(program)
+		if err != nil {
+			t.Fatal(err)
+		} else if !rc.IsEqual([]int64{12, 4}) {
+			t.Errorf("%v: expected (12 4), got '%v'.", kind, rc)
+		}
+	}
+}
+
+func TestRunProgramConcurrently(t *testing.T) {
+	// The macro isn't defined until the form runs, so the VM expands
+	// the call in code every run shares.
+	program, err := haki.Compile(`
+(do
+  (defmacro double (x) (list 'let (list 'y x) '(* y 2)))
+  (defun f (n) (let (a n) (+ a (double a))))
+  (list (f 1) (f 2)))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			interp := haki.NewInterpreter(haki.VM)
+			for j := 0; j < 3; j++ {
+				rc, err := interp.RunProgram(program)
+				if err != nil {
+					t.Error(err)
+				} else if !rc.IsEqual([]int64{3, 6}) {
+					t.Errorf("Expected (3 6), got '%v'.", rc)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSnapshotOfClosuresRestoresIntoEveryInterpreter(t *testing.T) {
+	program, err := haki.Compile(`
+(let (base 5
+      scale (* base 2))
+  (defun add-base (x) (+ x base scale)))
+
+(def add-k
+  (let (k 10)
+    (fn (x) (+ x k))))
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := haki.NewSnapshot(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		interp := haki.NewInterpreter(kind, haki.WithSnapshot(snap))
+
+		// Named functions in the Naive interpreter see their caller's
+		// environment, not the one they were defined in.
+		if kind != haki.Naive {
+			rc, err := interp.Call("add-base", haki.NewIntExpr(1))
+			if err != nil {
+				t.Errorf("add-base (%v): %v", kind, err)
+			} else if !rc.IsEqual(int64(16)) {
+				t.Errorf("add-base (%v): expected 16, got '%v'.", kind, rc)
+			}
+		}
+
+		rc, err := interp.Execute(`(add-k 1)`)
+		if err != nil {
+			t.Errorf("add-k (%v): %v", kind, err)
+		} else if !rc.IsEqual(int64(11)) {
+			t.Errorf("add-k (%v): expected 11, got '%v'.", kind, rc)
+		}
+	}
+}
+
+func BenchmarkNewInterpreterLoadingCore(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		interp := haki.NewInterpreter(haki.TCO)
+		if _, err := interp.Run(haki.NewReader(haki.Core)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewInterpreterFromSnapshot(b *testing.B) {
+	core, err := haki.CoreSnapshot()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		haki.NewInterpreter(haki.TCO, haki.WithSnapshot(core))
+	}
+}
+
+func BenchmarkRunCompiledProgram(b *testing.B) {
+	core, _ := haki.CoreSnapshot()
+	interp := haki.NewInterpreter(haki.TCO, haki.WithSnapshot(core))
+	program, err := haki.Compile(`(reduce + 0 (map inc (range 20)))`)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := interp.RunProgram(program); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	haki "github.com/zentrope/haki/lang"
+)
+
+const faultyScript = `; line 1
+(defun g (x)
+  (h x 1))
+
+(defun f (x)
+  (+ 1 (g x)))
+
+(f 2)
+`
+
+func runNamed(kind haki.Type, name, script string) error {
+	interp := haki.NewInterpreter(kind)
+	reader := haki.NewReader()
+	reader.AppendSource("<core>", haki.Core)
+	reader.AppendSource(name, script)
+	_, err := interp.Run(reader)
+	return err
+}
+
+func TestErrorPositionsAndStack(t *testing.T) {
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		err := runNamed(kind, "faulty.hk", faultyScript)
+
+		var e *haki.Error
+		if !errors.As(err, &e) {
+			t.Fatalf("Expected a *lang.Error, got '%v'.", err)
+		}
+
+		if e.Message != "value not found for 'h'" {
+			t.Errorf("Unexpected message '%v'.", e.Message)
+		}
+
+		expected := haki.Pos{File: "faulty.hk", Line: 3, Col: 4}
+		if e.Span.Start != expected {
+			t.Errorf("Expected error at %v, got %v.", expected, e.Span.Start)
+		}
+
+		calls := make([]string, 0)
+		for _, f := range e.Stack {
+			calls = append(calls, f.Name+"@"+f.Call.Start.String())
+		}
+		if strings.Join(calls, " ") != "g@faulty.hk:6:8 f@faulty.hk:8:1" {
+			t.Errorf("Unexpected stack %v.", calls)
+		}
+
+		diagnostic := e.Diagnostic()
+		for _, line := range []string{
+			"faulty.hk:3:4: error: value not found for 'h'",
+			"    3 |   (h x 1))",
+			"      |    ^",
+			"  in 'g', called from faulty.hk:6:8",
+		} {
+			if !strings.Contains(diagnostic, line+"\n") {
+				t.Errorf("Expected diagnostic to contain %q, got:\n%v", line, diagnostic)
+			}
+		}
+	}
+}
+
+func TestReadErrorPositions(t *testing.T) {
+	err := runNamed(haki.TCO, "broken.hk", "(prn 1)\n\n  (prn \"x\"\n")
+
+	var e *haki.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("Expected a *lang.Error, got '%v'.", err)
+	}
+
+	if e.Error() != "broken.hk:3:3: incomplete form (missing parens)" {
+		t.Errorf("Unexpected error '%v'.", e)
+	}
+}
+
+func TestErrorsWrapHostErrors(t *testing.T) {
+	sentinel := errors.New("host failure")
+
+	interp := haki.NewInterpreter(haki.TCO)
+	interp.Define("fail", func(args []haki.Expression) (haki.Expression, error) {
+		return haki.NilExpression, sentinel
+	})
+
+	_, err := interp.Execute("(do 1 (fail))")
+	if !errors.Is(err, sentinel) {
+		t.Errorf("Expected the host error to be wrapped, got '%v'.", err)
+	}
+
+	if err.Error() != "1:7: host failure" {
+		t.Errorf("Unexpected error '%v'.", err)
+	}
+}
+
+func TestHostErrorsAreCatchable(t *testing.T) {
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		interp := haki.NewInterpreter(kind)
+		interp.Define("fail", func(args []haki.Expression) (haki.Expression, error) {
+			return haki.NilExpression, errors.New("host failure")
+		})
+
+		rc, err := interp.Execute(`(try (fail) (catch e (error-message e)))`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rc.IsEqual("host failure") {
+			t.Errorf("Expected the host error's message, got '%v'.", rc)
+		}
+	}
+}
+
+func TestUncaughtThrowsReachHost(t *testing.T) {
+	interp := haki.NewInterpreter(haki.TCO)
+
+	_, err := interp.Execute(`(throw (error "bad input" 7))`)
+
+	var thrown *haki.ThrownError
+	if !errors.As(err, &thrown) {
+		t.Fatalf("Expected a *lang.ThrownError, got '%v'.", err)
+	}
+	if thrown.Value.Type() != "error" {
+		t.Errorf("Expected the thrown error value, got '%v'.", thrown.Value)
+	}
+}
+
+func TestQuotasAreNotCatchable(t *testing.T) {
+	interp := haki.NewInterpreter(haki.TCO, haki.WithLimits(haki.Limits{MaxSteps: 1000}))
+	form := `(defun spin (n) (spin (+ n 1))) (try (spin 0) (catch e "caught"))`
+
+	_, err := interp.Run(haki.NewReader(haki.Core, form))
+
+	var qe *haki.QuotaExceededError
+	if !errors.As(err, &qe) {
+		t.Errorf("Expected a *QuotaExceededError, got '%v'.", err)
+	}
+}
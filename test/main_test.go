@@ -19,13 +19,23 @@ package test
 import (
 	"fmt"
 	"io/ioutil"
+	"strings"
 	"testing"
 
 	haki "github.com/zentrope/haki/lang"
 )
 
-func evalForm(form string) (haki.Expression, error) {
-	interpreter := haki.NewInterpreter(haki.TCO)
+// evaluators are the interpreters every table is run against.
+var evaluators = []struct {
+	name string
+	kind haki.Type
+}{
+	{"tco", haki.TCO},
+	{"vm", haki.VM},
+}
+
+func evalForm(kind haki.Type, form string) (haki.Expression, error) {
+	interpreter := haki.NewInterpreter(kind)
 	reader := haki.NewReader(haki.Core, form)
 	return interpreter.Run(reader)
 }
@@ -37,20 +47,22 @@ type form struct {
 }
 
 func runExpressionTests(category string, table []form, t *testing.T) {
-	for _, row := range table {
-		t.Logf("%v: %v", category, row.form)
-		rc, err := evalForm(row.form)
-		if err != nil {
-			t.Error(err)
-		}
+	for _, e := range evaluators {
+		for _, row := range table {
+			t.Logf("%v (%v): %v", category, e.name, row.form)
+			rc, err := evalForm(e.kind, row.form)
+			if err != nil {
+				t.Errorf("%v: %v", e.name, err)
+			}
 
-		if !(rc.Type() == row.tag) {
-			t.Errorf("Expected '%v' result: %v → %v → %v", row.tag, row.expected, row.form, rc)
-		}
+			if !(rc.Type() == row.tag) {
+				t.Errorf("%v: Expected '%v' result: %v → %v → %v", e.name, row.tag, row.expected, row.form, rc)
+			}
 
-		if !rc.IsEqual(row.expected) {
-			t.Errorf("Expected '%v' result: %v → %v → %v (%v)",
-				row.tag, row.form, row.expected, rc, rc.Type())
+			if !rc.IsEqual(row.expected) {
+				t.Errorf("%v: Expected '%v' result: %v → %v → %v (%v)",
+					e.name, row.tag, row.form, row.expected, rc, rc.Type())
+			}
 		}
 	}
 }
@@ -69,11 +81,13 @@ func TestReadFile(t *testing.T) {
 	}
 
 	expr := fmt.Sprintf("(read-file \"%v\")", file.Name())
-	result, err := evalForm(expr)
-	if err != nil {
-		t.Error(err)
-	} else if !result.IsEqual(text) {
-		t.Errorf("Expected '%v', got '%v'.", text, result)
+	for _, e := range evaluators {
+		result, err := evalForm(e.kind, expr)
+		if err != nil {
+			t.Errorf("%v: %v", e.name, err)
+		} else if !result.IsEqual(text) {
+			t.Errorf("%v: Expected '%v', got '%v'.", e.name, text, result)
+		}
 	}
 }
 
@@ -109,6 +123,69 @@ func TestSimpleMath(t *testing.T) {
 	runExpressionTests("math", table, t)
 }
 
+func TestNumericTower(t *testing.T) {
+	table := []form{
+		{"float", float64(3), `(+ 1.0 2.0)`},
+		{"integer", int64(9007199254740993), `(+ 9007199254740992 1)`},
+		{"integer", int64(-5), `(- 5)`},
+		{"integer", int64(24), `(* 2 3 4)`},
+		{"float", float64(7.5), `(* 2.5 3)`},
+		{"integer", int64(3), `(/ 6 2)`},
+		{"float", float64(3.5), `(/ 7 2)`},
+		{"float", float64(0.25), `(/ 4)`},
+		{"float", float64(2), `(/ 6.0 3)`},
+		{"integer", int64(-3), `(quot -7 2)`},
+		{"integer", int64(-1), `(rem -7 2)`},
+		{"integer", int64(1), `(mod -7 2)`},
+		{"integer", int64(-1), `(mod 7 -2)`},
+		{"bool", true, `(< 1 2 3)`},
+		{"bool", false, `(< 1 3 2)`},
+		{"bool", true, `(<= 1 1 2)`},
+		{"bool", true, `(> 3 2.5 2)`},
+		{"bool", true, `(>= 3 3 1)`},
+		{"bool", true, `(== 1 1.0)`},
+		{"bool", false, `(== 9007199254740993 9007199254740992.0)`},
+		{"integer", int64(5), `(abs -5)`},
+		{"float", float64(2.5), `(abs -2.5)`},
+		{"integer", int64(1), `(min 3 1 2)`},
+		{"float", float64(3.5), `(max 3 1 3.5)`},
+		{"integer", int64(2), `(floor 2.7)`},
+		{"integer", int64(-2), `(ceil -2.7)`},
+		{"integer", int64(3), `(round 2.5)`},
+		{"integer", int64(7), `(round 7)`},
+		{"float", float64(3), `(sqrt 9)`},
+		{"integer", int64(1024), `(pow 2 10)`},
+		{"float", float64(0.5), `(pow 2 -1)`},
+		{"float", float64(1), `(exp 0)`},
+		{"float", float64(0), `(log 1)`},
+		{"float", float64(2), `(float 2)`},
+		{"bool", true, `(and (number? 1) (number? 1.5) (integer? 1) (float? 1.5) (not (float? 1)))`},
+	}
+	runExpressionTests("numeric tower", table, t)
+}
+
+func TestMathErrors(t *testing.T) {
+	table := []failure{
+		{`(+ 9223372036854775807 1)`, "integer overflow for 9223372036854775807 1"},
+		{`(- -9223372036854775807 2)`, "integer overflow"},
+		{`(* 4611686018427387904 2)`, "integer overflow for 4611686018427387904 2"},
+		{`(- (- -9223372036854775807 1))`, "integer overflow for -9223372036854775808"},
+		{`(abs (- -9223372036854775807 1))`, "integer overflow"},
+		{`(pow 2 64)`, "integer overflow"},
+		{`(/ 1 0)`, "divide by zero"},
+		{`(/ 1.5 0.0)`, "divide by zero"},
+		{`(quot 1 0)`, "divide by zero"},
+		{`(mod 1 0)`, "divide by zero"},
+		{`(sqrt -1)`, "no finite result"},
+		{`(* 1e308 10.0)`, "no finite result"},
+		{`(/ 1e-320)`, "no finite result for 1e-320"},
+		{`(floor 1e20)`, "out of integer range"},
+		{`(+ 1 "2")`, "'(+ num…)' expects arg '2' to be type 'integer or float', not 'string'"},
+		{`(quot 1.5 2)`, "expects arg '1' to be type 'integer'"},
+	}
+	runErrorTests(table, t)
+}
+
 func TestStringBuiltins(t *testing.T) {
 	// A set of starter tests (happy path).
 	table := []form{
@@ -130,6 +207,53 @@ func TestStringBuiltins(t *testing.T) {
 	runExpressionTests("string", table, t)
 }
 
+func TestStringLiterals(t *testing.T) {
+	table := []form{
+		{"string", `say "hi"`, `"say \"hi\""`},
+		{"string", `a\b`, `"a\\b"`},
+		{"string", "a\nb\tc\r", `"a\nb\tc\r"`},
+		{"string", "é☃", `"\u00e9\u2603"`},
+		{"string", `\d+`, `"\d+"`},
+		{"integer", 0, `(count "")`},
+		{"string", "(; not a comment", `"(; not a comment" ; but this is`},
+		{"string", `{"a": [1, "\n"]}`, `"""{"a": [1, "\n"]}"""`},
+		{"string", `say "hi"`, `"""say "hi""""`},
+		{"string", "one\ntwo", "\"\"\"one\ntwo\"\"\""},
+		{"bool", true, `(= "a\"b\\c\nd" (read-string (pr-str "a\"b\\c\nd")))`},
+		{"string", `"a\"b\\c\nd\u0001"`, `(pr-str "a\"b\\c\nd\u0001")`},
+	}
+	runExpressionTests("string literals", table, t)
+}
+
+func TestStringLiteralErrors(t *testing.T) {
+	table := []failure{
+		{`(prn "abc`, "incomplete form"},
+		{`(prn 1) "abc`, "unterminated string"},
+		{`"""abc"`, "unterminated string"},
+		{`"\u12"`, "invalid escape '\\u12' in string"},
+		{`"\uzzzz"`, "invalid escape '\\uzzzz' in string"},
+	}
+	runErrorTests(table, t)
+}
+
+func TestReaderTracksOpenStrings(t *testing.T) {
+	reader := haki.NewReader()
+	reader.Append(`(def x """one ; not a comment`)
+	if reader.IsBalanced() {
+		t.Error("Expected an open string to leave the reader unbalanced.")
+	}
+
+	reader.Append("\ntwo ( \"\"\") ; comment (")
+	if !reader.IsBalanced() {
+		t.Error("Expected a closed string to balance the reader.")
+	}
+
+	forms, err := reader.GetForms()
+	if err != nil || len(forms) != 1 || !strings.Contains(forms[0], "; not a comment") {
+		t.Errorf("Unexpected forms %q (%v).", forms, err)
+	}
+}
+
 func TestLogicBuiltins(t *testing.T) {
 	table := []form{
 		{"bool", true, `(true? true)`},
@@ -153,3 +277,340 @@ func TestLetRecursive(t *testing.T) {
 	}
 	runExpressionTests("letrec", table, t)
 }
+
+func TestMacros(t *testing.T) {
+	table := []form{
+		{"integer", int64(2), "(defmacro unless (c x) `(if ~c nil ~x)) (unless false 2)"},
+		{"bool", true, "(defmacro unless (c x) `(if ~c nil ~x)) (nil? (unless true 2))"},
+		{"integer", int64(16), "(do (defmacro sq (x) (let (v (gensym)) `(let (~v ~x) (* ~v ~v)))) (sq (+ 1 3)))"},
+		{"list", []int64{0, 1, 2, 3}, "(def xs '(1 2)) `(0 ~@xs ~(+ 1 2))"},
+		{"bool", true, "(defmacro unless (c x) `(if ~c nil ~x)) (= (macroexpand '(unless a b)) '(if a nil b))"},
+		{"integer", int64(9), "(defmacro flip (f a b) `(~f ~b ~a)) (defun g (x) (flip - 1 x)) (g 10)"},
+		{"string", "~/x@y", `"~/x@y"`},
+	}
+	runExpressionTests("macros", table, t)
+}
+
+func TestQuasiquoteCollections(t *testing.T) {
+	table := []string{
+		"(def x 1) (def ys '(2 3)) (= `[0 ~x ~@ys] [0 1 2 3])",
+		"(def v 2) (= `{:k ~v :l (+ 1 ~v)} {:k 2 :l '(+ 1 2)})",
+		"(def k :a) (= `{~k [~k]} {:a [:a]})",
+		"(def x 1) (def ys '(2 3)) (= `#{~x ~@ys} #{1 2 3})",
+		"(def x 1) (= `(a [~x {:b ~x}]) '(a [1 {:b 1}]))",
+		"(defmacro pair (a b) `[~a ~b]) (= (pair (+ 1 1) 3) [2 3])",
+	}
+
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		for _, form := range table {
+			rc, err := evalForm(kind, form)
+			if err != nil {
+				t.Errorf("%v (%v): %v", form, kind, err)
+			} else if !rc.IsEqual(true) {
+				t.Errorf("%v (%v): expected true, got '%v'.", form, kind, rc)
+			}
+		}
+
+		if _, err := evalForm(kind, "(def ys '(1 2)) `{:k ~@ys}"); err == nil {
+			t.Errorf("Expected splicing into a hash-map to fail (%v).", kind)
+		}
+	}
+}
+
+func TestOptionalAndRestParams(t *testing.T) {
+	table := []form{
+		{"list", []int64{2, 3}, `(defun f (a & xs) xs) (f 1 2 3)`},
+		{"bool", true, `(defun f (a & xs) xs) (= (f 1) '())`},
+		{"integer", int64(11), `(defun f (a &opt (b 10)) (+ a b)) (f 1)`},
+		{"integer", int64(3), `(defun f (a &opt (b 10)) (+ a b)) (f 1 2)`},
+		{"integer", int64(4), `(defun f (a &opt (b (* a 2))) (+ a b)) (f 1 3)`},
+		{"integer", int64(3), `(defun f (a &opt (b (* a 2))) (+ a b)) (f 1)`},
+		{"bool", true, `(defun f (&opt b) (nil? b)) (f)`},
+		{"list", []int64{1, 2, 3}, `(defun f (&opt (a 1) & xs) (prepend a xs)) (f 1 2 3)`},
+		{"integer", int64(6), `((fn (& xs) (reduce + 0 xs)) 1 2 3)`},
+		{"integer", int64(3), `(defmacro my-do (& body) (prepend 'do body)) (my-do 1 2 3)`},
+	}
+	runExpressionTests("params", table, t)
+}
+
+type failure struct {
+	form     string
+	expected string
+}
+
+func runErrorTests(table []failure, t *testing.T) {
+	for _, e := range evaluators {
+		for _, row := range table {
+			_, err := evalForm(e.kind, row.form)
+			if err == nil || !strings.Contains(err.Error(), row.expected) {
+				t.Errorf("%v: %v: expected error containing %q, got '%v'.", e.name, row.form, row.expected, err)
+			}
+		}
+	}
+}
+
+func TestArityErrors(t *testing.T) {
+	table := []failure{
+		{`(defun f (a b) a) (f 1)`, "fn 'f' takes 2 param(s), you provided 1"},
+		{`(defun f (a &opt b c) a) (f 1 2 3 4)`, "fn 'f' takes 1 to 3 param(s), you provided 4"},
+		{`(defun f (a b & c) a) (f 1)`, "fn 'f' takes at least 2 param(s), you provided 1"},
+		{`(defun f (a & b c) a)`, "'&' must be followed by exactly one name"},
+		{`(defun f (& a &opt b) a)`, "'&' must be followed by exactly one name"},
+		{`(defun f (a &opt (b)) a)`, "optional param '(b)' must be a name or (name default)"},
+	}
+	runErrorTests(table, t)
+}
+
+func TestDestructuring(t *testing.T) {
+	table := []form{
+		{"integer", int64(3), `(let ((a b) '(1 2)) (+ a b))`},
+		{"list", []int64{2, 3}, `(let ((a & more) '(1 2 3)) more)`},
+		{"integer", int64(4), `(let ((a (b c)) '(1 (2 3)) d (+ a c)) d)`},
+		{"integer", int64(2), `(let ((_ x _) '(1 2 3)) x)`},
+		{"bool", true, `(let ((& xs) nil) (= xs '()))`},
+		{"string", "0", `(let ({:keys (exit)} (exec!! "true")) exit)`},
+		{"string", "hi", `(let ({:keys (stdout)} (exec!! "echo" "hi")) (trim stdout))`},
+		{"integer", int64(3), `(let ({:keys (a b)} (hmap "a" 1 "b" 2)) (+ a b))`},
+		{"bool", true, `(let ({:keys (a) :as m} (hmap 'a 1)) (hmap? m))`},
+		{"integer", int64(5), `(let ({(x y) "pt"} (hmap "pt" '(2 3))) (+ x y))`},
+		{"integer", int64(7), `(let ({n 'n} (hmap 'n 7)) n)`},
+		{"bool", true, `(let ({:keys (missing)} (hmap)) (nil? missing))`},
+		{"integer", int64(6), `(defun f ((a b) c) (+ a b c)) (f '(1 2) 3)`},
+		{"integer", int64(5), `((fn ({:keys (n)}) n) (hmap "n" 5))`},
+		{"list", []int64{3, 7}, `(def out '()) (loop (fn ((a b)) (def out (append out (+ a b)))) '((1 2) (3 4))) out`},
+		{"integer", int64(2), `(let ((ok code out) (exec! "true")) (if ok 2 3))`},
+		{"string", "0", `(let ((hmap :keys (exit)) (exec!! "true")) exit)`},
+		{"integer", int64(3), `(let ((hmap :keys (a b)) (hmap "a" 1 "b" 2)) (+ a b))`},
+		{"bool", true, `(let ((hmap :keys (a) :as m) (hmap 'a 1)) (hmap? m))`},
+		{"integer", int64(5), `(let ((hmap (x y) "pt") (hmap "pt" '(2 3))) (+ x y))`},
+		{"integer", int64(5), `((fn ((hmap :keys (n))) n) (hmap "n" 5))`},
+		{"list", []int64{1, 2}, `(def out '()) (loop (fn ((hmap :keys (n))) (def out (append out n))) (list (hmap 'n 1) (hmap 'n 2))) out`},
+	}
+	runExpressionTests("destructure", table, t)
+}
+
+func TestDestructuringErrors(t *testing.T) {
+	table := []failure{
+		{`(let ((a b) '(1 2 3)) a)`, "(a b) expects 2 value(s), got 3"},
+		{`(let ((a b & c) '(1)) a)`, "(a b & c) expects at least 2 value(s), got 1"},
+		{`(let ((a b) 5) a)`, "cannot destructure integer '5' with (a b)"},
+		{`(let ({:keys (a)} '(1)) a)`, "cannot destructure list '(1)' with {:keys (a)}"},
+		{`(let ((a 1) '(1 2)) a)`, "'1' is not a name or pattern"},
+		{`(let ({:keys a} (hmap)) a)`, "':keys' in {:keys a} must be followed by a list of names"},
+		{`(defun f ((a b)) a) (f '(1))`, "(a b) expects 2 value(s), got 1"},
+		{`(defun f ((a &)) a)`, "'&' in (a &) must be followed by exactly one pattern"},
+		{`(let ((hmap :keys) (hmap)) 1)`, "(hmap :keys) expects an even number of keys and patterns"},
+	}
+	runErrorTests(table, t)
+}
+
+func TestTryCatch(t *testing.T) {
+	table := []form{
+		{"string", "caught", `(try (throw "x") (catch e "caught"))`},
+		{"integer", int64(2), `(try 1 2)`},
+		{"string", "x", `(try (throw "x") (catch e (error-message e)))`},
+		{"integer", int64(42), `(try (throw 42) (catch e (error-data e)))`},
+		{"integer", int64(3), `(try (throw (error "bad" 3)) (catch e (error-data e)))`},
+		{"string", "bad", `(try (throw (error "bad" 3)) (catch e (error-message e)))`},
+		{"bool", true, `(try (read-file "/no/such/file") (catch e (error? e)))`},
+		{"bool", true, `(try (re-find "[" "x") (catch e (starts-with? (error-message e) "error parsing regexp")))`},
+		{"bool", false, `(error? "x")`},
+		{"list", []int64{1, 2}, `(def log '()) (try (def log (append log 1)) (finally (def log (append log 2)))) log`},
+		{"list", []int64{1, 2}, `(def log '()) (try (try (throw 0) (finally (def log (append log 1)))) (catch e (def log (append log 2)))) log`},
+		{"string", "inner", `(try (try (throw "inner") (catch e (throw e))) (catch e (error-message e)))`},
+		{"bool", true, `(defun g () (+ 1 (throw "x"))) (try (+ 1 (g)) (catch e (starts-with? (head (error-stack e)) "g ")))`},
+		{"string", "none", `(defun slurp (f) (try (read-file f) (catch e "none"))) (slurp "/no/such/file")`},
+	}
+	runExpressionTests("try", table, t)
+}
+
+func TestTryErrors(t *testing.T) {
+	table := []failure{
+		{`(throw "boom")`, `uncaught throw: "boom"`},
+		{`(throw (error "boom"))`, "boom"},
+		{`(try (throw "x") (catch e (throw "y")))`, `uncaught throw: "y"`},
+		{`(try 1 (finally (throw "z")))`, `uncaught throw: "z"`},
+		{`(try 1 (finally 2) (catch e 3))`, "'catch' must come last"},
+		{`(try 1 (catch (a) 3))`, "catch must name the error"},
+		{`(error-message "x")`, "'(error-message err)' expects arg '1' to be type 'error'"},
+	}
+	runErrorTests(table, t)
+}
+
+func TestControlFlow(t *testing.T) {
+	table := []form{
+		{"string", "neg", `(defun sign (x) (cond (< x 0) "neg" (= x 0) "zero" else "pos")) (sign -3)`},
+		{"string", "zero", `(defun sign (x) (cond (< x 0) "neg" (= x 0) "zero" else "pos")) (sign 0)`},
+		{"string", "pos", `(defun sign (x) (cond (< x 0) "neg" (= x 0) "zero" true "pos")) (sign 3)`},
+		{"bool", true, `(nil? (cond false 1))`},
+		{"string", "two", `(case (+ 1 1) 1 "one" 2 "two" "many")`},
+		{"string", "many", `(case 7 1 "one" 2 "two" "many")`},
+		{"string", "vowel", `(case "e" ("a" "e" "i" "o" "u") "vowel" "consonant")`},
+		{"string", "sym", `(case 'b a "nope" b "sym")`},
+		{"bool", true, `(nil? (case 3 1 "one"))`},
+		{"integer", int64(3), `(when true 1 2 3)`},
+		{"bool", true, `(nil? (when false 1))`},
+		{"integer", int64(2), `(unless false 1 2)`},
+		{"bool", true, `(nil? (unless true 1))`},
+		{"integer", int64(45), `(let next (i 0 total 0) (if (< i 10) (next (inc i) (+ total i)) total))`},
+		{"list", []int64{3, 2, 1}, `(let rev ((x & xs) '(1 2 3) out '()) (if (= 0 (count xs)) (prepend x out) (rev xs (prepend x out))))`},
+		{"integer", int64(20000), `(let count (n 0) (cond (< n 20000) (count (inc n)) else n))`},
+	}
+	runExpressionTests("control", table, t)
+}
+
+func TestNamedLetSpecialFormNames(t *testing.T) {
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		for _, name := range []string{"loop", "if", "lambda"} {
+			form := fmt.Sprintf("(let %v (i 0) (if (< i 3) (%v (+ i 1)) i))", name, name)
+			_, err := evalForm(kind, form)
+			if err == nil || !strings.Contains(err.Error(), "which is a special form") {
+				t.Errorf("%v (%v): expected a special form name error, got '%v'.", form, kind, err)
+			}
+		}
+	}
+}
+
+func TestMutation(t *testing.T) {
+	table := []form{
+		{"integer", int64(2), `(let (x 1) (set! x 2) x)`},
+		{"integer", int64(5), `(let (x 1) (let (y 2) (set! x 5)) x)`},
+		{"integer", int64(8), `(defun f (x) (set! x (* x 2)) x) (f 4)`},
+		{"integer", int64(3), `(let (n 0) (def counter (fn () (set! n (inc n))))) (counter) (counter) (counter)`},
+		{"integer", int64(6), `(let (total 0) (loop (fn (x) (set! total (+ total x))) '(1 2 3)) total)`},
+		{"integer", int64(2), `(def g 1) (set! g 2) g`},
+		{"integer", int64(10), `(def a (atom 0)) (loop (fn (i) (swap! a + i)) (range 5)) (deref a)`},
+		{"integer", int64(7), `(def a (atom 0)) (reset! a 7) (deref a)`},
+		{"bool", true, `(def a (atom 0)) (compare-and-set! a 0 1)`},
+		{"integer", int64(1), `(def a (atom 1)) (compare-and-set! a 0 2) (deref a)`},
+		{"integer", int64(30), `(def a (atom 1)) (swap! a (fn (x y z) (* x y z)) 5 6)`},
+		{"bool", true, `(atom? (atom nil))`},
+		{"bool", false, `(atom? 1)`},
+		{"integer", int64(2), `(def cache (atom (hmap))) (defun lookup (k) (let (hit (hget (deref cache) k)) (if hit hit (do (swap! cache hset k 2) 0)))) (lookup "a") (lookup "a")`},
+	}
+	runExpressionTests("mutation", table, t)
+}
+
+func TestLexicalScope(t *testing.T) {
+	table := []form{
+		{"integer", int64(2), `(let (x 1) (let (x 2) x))`},
+		{"integer", int64(1), `(let (x 1) (let (x 2) x) x)`},
+		{"integer", int64(0), `(defun f () y) (defun g (y) (f)) (def y 0) (g 5)`},
+		{"integer", int64(3), `(defun make (n) (fn () n)) (let (a (make 1) b (make 2)) (+ (a) (b)))`},
+		{"integer", int64(2), `(let (n 0 bump (fn () (set! n (inc n))) get (fn () n)) (bump) (bump) (get))`},
+		{"list", []int64{1, 2, 3}, `(map (fn (f) (f)) (map (fn (i) (fn () i)) '(1 2 3)))`},
+	}
+	runExpressionTests("scope", table, t)
+}
+
+func TestMutationErrors(t *testing.T) {
+	table := []failure{
+		{`(set! nope 1)`, "'nope' is not defined"},
+		{`(set! "x" 1)`, "'(set! name val)' expects arg '1' to be type 'symbol'"},
+		{`(deref 1)`, "'(deref atom)' expects arg '1' to be type 'atom'"},
+		{`(swap! 1 inc)`, "'(swap! atom fn args…)' expects arg '1' to be type 'atom'"},
+	}
+	runErrorTests(table, t)
+}
+
+func TestPersistentCollections(t *testing.T) {
+	table := []form{
+		{"list", []int64{1, 2, 4}, `(def a (list 1 2)) (def b (append a 3)) (append a 4)`},
+		{"list", []int64{1, 2, 3, 5}, `(def a (list 1 2)) (def b (append a 3)) (def c (append a 4)) (append b 5)`},
+		{"list", []int64{9, 1, 2}, `(def a (list 1 2)) (def b (prepend 0 a)) (prepend 9 a)`},
+		{"list", []int64{1, 2}, `(def a (list 1 2)) (def b (append a 3)) (def c (prepend 0 a)) a`},
+		{"bool", true, `(= (append '(1 2) 3) '(1 2 3))`},
+		{"bool", true, `(= (prepend 0 '(1 2)) '(0 1 2))`},
+		{"bool", true, `(= (tail (prepend 0 (append '(1) 2))) '(1 2))`},
+		{"bool", true, `(= (tail '(1)) '())`},
+		{"integer", int64(5000), `(count (reduce (fn (l i) (append l i)) '() (range 5000)))`},
+		{"integer", int64(5000), `(count (reduce (fn (m i) (hset m i i)) (hmap) (range 5000)))`},
+		{"integer", int64(2500), `(def m (reduce (fn (m i) (hset m i i)) (hmap) (range 5000))) (count (reduce (fn (m i) (if (even? i) (hset m i nil) m)) m (range 5000)))`},
+		{"list", []int64{4999, 5000}, `(def m (reduce (fn (m i) (hset m i i)) (hmap) (range 5000))) (def n (reduce (fn (m i) (if (even? i) (hset m i nil) m)) m (range 5000))) (list (hget n 4999) (count m))`},
+		{"integer", int64(1), `(def m (hmap :a 1 :b 2)) (count (hset m :a nil))`},
+		{"integer", int64(1), `(def m (hmap :a 1 :b 2)) (hset m :a nil) (hget m :a)`},
+		{"bool", true, `(= (hset (hmap) :b 2 :a 1) (hmap :a 1 :b 2))`},
+		{"bool", false, `(= {:a 1} {:a 2})`},
+		{"integer", int64(1), `(def m {:a {:b 1}}) (hset-in m '(:a :b) 2) (hget-in m '(:a :b))`},
+		{"integer", int64(3), `(hget-in (hset-in {:a {:b 1}} '(:x :y) 3) '(:x :y))`},
+	}
+	runExpressionTests("persistent", table, t)
+}
+
+func TestEquality(t *testing.T) {
+	// "k512789" and "k749192" have the same hash.
+	table := []form{
+		{"bool", false, `(= "k512789" "k749192")`},
+		{"integer", int64(2), `(count (hmap "k512789" 1 "k749192" 2))`},
+		{"integer", int64(2), `(hget (hmap "k512789" 1 "k749192" 2) "k749192")`},
+		{"integer", int64(1), `(hget (hmap "k512789" 1 "k749192" 2) "k512789")`},
+		{"integer", int64(2), `(hget (hset (hmap "k512789" 1 "k749192" 2) "k512789" nil) "k749192")`},
+		{"bool", false, `(contains? (hset (hmap "k512789" 1 "k749192" 2) "k512789" nil) "k512789")`},
+		{"integer", int64(2), `(count #{"k512789" "k749192"})`},
+		{"bool", true, `(= (hmap "k512789" 1 "k749192" 2) (hmap "k749192" 2 "k512789" 1))`},
+		{"bool", false, `(= (hmap "k512789" 1 "k749192" 2) (hmap "k512789" 2 "k749192" 1))`},
+		{"bool", false, `(= 1 1.0)`},
+		{"bool", true, `(== 1 1.0)`},
+		{"bool", false, `(= 0.0 -0.0)`},
+		{"integer", int64(2), `(count (hmap 1 :a 1.0 :b))`},
+		{"keyword", ":b", `(hget (hmap 1 :a 1.0 :b) 1.0)`},
+		{"bool", true, `(= {:a [1 {:b 2}]} {:a [1 {:b 2}]})`},
+		{"bool", false, `(= [1 2] '(1 2))`},
+		{"bool", true, `(defun f () 1) (= f f)`},
+		{"bool", false, `(= + -)`},
+		{"bool", false, `(def a (atom 1)) (= a (atom 1))`},
+	}
+	runExpressionTests("equality", table, t)
+}
+
+func TestLiterals(t *testing.T) {
+	table := []form{
+		{"keyword", ":k", `:k`},
+		{"bool", true, `(= :k (keyword "k"))`},
+		{"bool", false, `(= :k 'k)`},
+		{"integer", int64(3), `(count [1 2 (+ 1 2)])`},
+		{"integer", int64(3), `(nth [1 2 (+ 1 2)] 2)`},
+		{"bool", true, `(= [1 2] (vector 1 2))`},
+		{"bool", false, `(= [1 2] '(1 2))`},
+		{"integer", int64(2), `(hget {:a 1 :b (inc 1)} :b)`},
+		{"bool", true, `(= {:a 1 "b" 2} (hmap "b" 2 :a 1))`},
+		{"bool", true, `(= #{1 2 3} #{3 2 1})`},
+		{"bool", true, `(contains? #{:a (keyword "b")} :b)`},
+		{"bool", false, `(contains? #{:a} :b)`},
+		{"integer", int64(2), `(count #{1 (- 2 1) 2})`},
+		{"integer", int64(6), `(let ([a b & r] [1 2 3]) (+ a b (head r)))`},
+		{"integer", int64(3), `(let ({:keys [a b]} {:a 1 :b 2}) (+ a b))`},
+		{"integer", int64(4), `(defmacro twice (x) (list '+ x x)) (head [(twice 2)])`},
+		{"bool", true, `(keyword? :k)`},
+		{"bool", true, `(vector? [])`},
+		{"bool", true, `(set? #{})`},
+	}
+	runExpressionTests("literals", table, t)
+}
+
+func TestReadablePrinting(t *testing.T) {
+	table := []form{
+		{"string", `:k [1 2.0 "s"] {"b" 2 :a 1} #{1 2 3} (a 'b)`,
+			`(pr-str :k [1 2.0 "s"] {:a 1 "b" 2} #{3 1 2} '(a 'b))`},
+		{"string", "1.0", `(pr-str 1.0)`},
+		{"bool", true, `(def x {:name "x" :tags #{:a :b} :v [1 [2.5 nil] {}]}) (= x (read-string (pr-str x)))`},
+		{"bool", true, `(def x '(a :b "c" [d])) (= x (read-string (pr-str x)))`},
+		{"string", "3.0", `(pr-str (read-string (pr-str 3.0)))`},
+		{"keyword", ":k", `(read-string ":k")`},
+		{"bool", true, `(nil? (read-string "nil"))`},
+		{"bool", true, `(= (read-string "[nil true (false) {:a #{true}}]") [nil true (list false) {:a #{true}}])`},
+		{"bool", true, `(def x {:a [nil false] :b (list true)}) (= x (read-string (pr-str x)))`},
+	}
+	runExpressionTests("printing", table, t)
+}
+
+func TestLiteralErrors(t *testing.T) {
+	table := []failure{
+		{`{:a 1 :b}`, "map literal must contain key/value pairs"},
+		{`{:a 1 :a 2}`, "duplicate key ':a' in map literal"},
+		{`#{1 1}`, "duplicate element in set literal"},
+		{`[1 2)`, "unexpected ')' closing '['"},
+		{`(read-string " ")`, "no form to read"},
+	}
+	runErrorTests(table, t)
+}
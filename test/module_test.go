@@ -0,0 +1,134 @@
+//
+// Copyright © 2017-present Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	haki "github.com/zentrope/haki/lang"
+)
+
+// writeFiles creates the named files (paths relative to a new temp
+// dir), returning the dir.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "haki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func runScript(kind haki.Type, path string, opts ...haki.Option) (haki.Expression, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return haki.NilExpression, err
+	}
+	interp := haki.NewInterpreter(kind, opts...)
+	reader := haki.NewReader()
+	reader.AppendSource("<core>", haki.Core)
+	reader.AppendSource(path, string(text))
+	return interp.Run(reader)
+}
+
+var library = map[string]string{
+	"lib/text/util.hk": `
+(ns text.util)
+(def sep "-")
+(defun helper (x) (format "%v%v" x sep))
+(defun shout (x) (upper-case (helper x)))
+(defmacro twice (x) ` + "`" + `(helper (helper ~x)))
+`,
+	"app/local.hk": `
+(ns local)
+(require 'text.util :as u)
+(defun greet (n) (u/shout n))
+`,
+	"app/more.hk": `
+(def loaded 42)
+`,
+	"app/main.hk": `
+(load "more.hk")
+(defun helper (x) "mine")
+(require 'text.util :as u)
+(require 'local)
+(list loaded (helper 1) (u/shout "a") (text.util/shout "b") (local/greet "c") (u/twice "d"))
+`,
+}
+
+func TestRequireAndLoad(t *testing.T) {
+	dir := writeFiles(t, library)
+	defer os.RemoveAll(dir)
+
+	for _, kind := range []haki.Type{haki.TCO, haki.Naive, haki.VM} {
+		rc, err := runScript(kind, filepath.Join(dir, "app/main.hk"),
+			haki.WithLoadPath(filepath.Join(dir, "lib")))
+		if err != nil {
+			t.Fatalf("%v: %v", kind, err)
+		}
+		if got := rc.String(); got != `(42 "mine" "A-" "B-" "C-" "d--")` {
+			t.Errorf("%v: unexpected result %v", kind, got)
+		}
+	}
+}
+
+func TestRequireErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"missing.hk":    `(require 'nope)`,
+		"undeclared.hk": `(require 'plain)`,
+		"plain.hk":      `(def x 1)`,
+		"broken.hk":     `(require 'bad)`,
+		"bad.hk":        "(ns bad)\n(oops)",
+		"pure.hk":       `(load "plain.hk")`,
+	})
+	defer os.RemoveAll(dir)
+
+	table := []struct {
+		script   string
+		expected string
+	}{
+		{"missing.hk", "can't find 'nope.hk'"},
+		{"undeclared.hk", "must declare (ns plain)"},
+		{"broken.hk", "bad.hk:2:2: value not found for 'oops'"},
+	}
+
+	for _, row := range table {
+		_, err := runScript(haki.TCO, filepath.Join(dir, row.script))
+		if err == nil || !strings.Contains(err.Error(), row.expected) {
+			t.Errorf("%v: expected error containing %q, got '%v'.", row.script, row.expected, err)
+		}
+	}
+
+	_, err := runScript(haki.TCO, filepath.Join(dir, "pure.hk"), haki.WithCapabilities(haki.Pure))
+	var perm *haki.PermissionError
+	if !errors.As(err, &perm) {
+		t.Errorf("Expected a permission error loading in a pure sandbox, got '%v'.", err)
+	}
+}